@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hylandsoftware/trebuchet/internal/credhelper"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
+	"github.com/spf13/cobra"
+)
+
+// credentialHelperPrefix is the prefix of the executable names that the Docker CLI runs as credential helpers
+const credentialHelperPrefix = "docker-credential-"
+
+var credentialHelperCmd = &cobra.Command{
+	Use:       "credential-helper get|store|erase|list",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"get", "store", "erase", "list"},
+	Short:     "Acts as a Docker credential helper for ECR registries",
+	Example: `echo 112233445566.dkr.ecr.us-east-1.amazonaws.com | treb credential-helper get
+echo 112233445566.dkr.ecr.us-east-1.amazonaws.com | docker-credential-treb get`,
+	Long: `Acts as a Docker credential helper for ECR registries, implementing the protocol of the Docker CLI credential
+helpers. Tools that read the Docker configuration can then authenticate to any ECR registry by its host name. The
+authorization token is taken from the cache and only requested again shortly before it expires.
+
+Setup:
+	Copy or link the treb binary as docker-credential-treb somewhere on the PATH, which makes it run as the
+	credential helper. Then configure the Docker CLI to use it in ~/.docker/config.json, either for every registry
+	with "credsStore": "treb" or for specific registries with
+	"credHelpers": {"112233445566.dkr.ecr.us-east-1.amazonaws.com": "treb"}.
+
+Configuration:
+	The region and registry ID are taken from the host name of the registry. Credentials, the profile and the role
+	to assume are resolved as for every other command, from the AWS environment variables and configuration files
+	and the trebuchet configuration file, as the Docker CLI does not pass any flags to credential helpers.
+
+Actions:
+	get returns credentials for ECR registries and reports that no credentials are found for other registries. store
+	and erase are accepted but have no effect, and list returns no registries, as no credentials are stored.`,
+	Run: credentialHelper,
+}
+
+func credentialHelper(cmd *cobra.Command, args []string) {
+	err := credhelper.Serve(args[0], os.Stdin, os.Stdout, func(registry string) (ecr.Client, error) {
+		return newECRClient(reference.Reference{Registry: registry})
+	})
+
+	// The Docker CLI reads errors of credential helpers from stdout
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(credentialHelperCmd)
+}
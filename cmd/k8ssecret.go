@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/kubernetes"
+	"github.com/hylandsoftware/trebuchet/internal/output"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// tokenLifetime is how long ECR authorization tokens are valid for
+	tokenLifetime = 12 * time.Hour
+
+	// secretRetryInterval is how long to wait before retrying when refreshing the secret fails
+	secretRetryInterval = time.Minute
+)
+
+var k8sSecretCmd = &cobra.Command{
+	Use:   "k8s-secret [REGISTRY]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Renders a Kubernetes image pull secret for ECR",
+	Example: `treb k8s-secret --name ecr --namespace app --region us-east-1 | kubectl apply -f -
+treb k8s-secret --name ecr --namespace app 112233445566.dkr.ecr.eu-west-1.amazonaws.com --output json
+treb k8s-secret --name ecr --region us-east-1 --refresh-every 6h --out /secrets/ecr.yaml`,
+	Long: `Renders a Kubernetes Secret of type kubernetes.io/dockerconfigjson with an ECR authorization token, which can
+be used as an imagePullSecret by clusters outside of AWS. The manifest is printed as YAML, or as JSON when the output
+flag is set to json.
+
+Registry:
+	By default the registry of the caller's account (or the registry ID flag) in the configured region is used. A
+	registry host name such as 112233445566.dkr.ecr.eu-west-1.amazonaws.com selects that registry and region instead.
+
+Refreshing:
+	ECR authorization tokens expire after 12 hours. When refresh-every is set, the command keeps running and renders
+	the secret again with a new token at that interval, until it is stopped. Together with out, which replaces the
+	file atomically, this allows running trebuchet as a sidecar that keeps a secret up to date. A fresh token is
+	always requested, rather than reusing a cached one that may expire sooner.`,
+	Run: k8sSecret,
+}
+
+func k8sSecret(cmd *cobra.Command, args []string) {
+	printer, err := newPrinter()
+	if err != nil {
+		log.WithError(err).Fatal("Error in output options")
+	}
+
+	ref, err := registryReference(args)
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing registry")
+	}
+
+	// A cached token may expire long before the secret is refreshed
+	viper.Set("no-cache", true)
+
+	interval := viper.GetDuration("refresh-every")
+	if interval >= tokenLifetime {
+		log.WithField("interval", interval).Warn("Refresh interval is longer than the lifetime of ECR tokens")
+	}
+
+	ecrClient, err := newECRClient(ref)
+	if err != nil {
+		log.WithError(err).Fatal("Error in creation of ECR client")
+	}
+
+	if interval <= 0 {
+		if err := writeSecret(printer, ecrClient); err != nil {
+			log.WithError(err).Fatal("Error rendering secret")
+		}
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		wait := interval
+		if err := writeSecret(printer, ecrClient); err != nil {
+			log.WithError(err).WithField("retry", secretRetryInterval).Error("Error refreshing secret")
+			wait = secretRetryInterval
+		} else {
+			log.WithField("next", time.Now().Add(wait).Format(time.RFC3339)).Info("Refreshed secret")
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-signals:
+			log.Info("Stopped refreshing secret")
+			return
+		}
+	}
+}
+
+// writeSecret renders the secret with a new authorization token and writes it to stdout or the out file
+func writeSecret(printer output.Printer, ecrClient ecr.Client) error {
+	auth, err := ecrClient.GetAuthorizationToken()
+	if err != nil {
+		return err
+	}
+
+	secret, err := kubernetes.NewDockerConfigSecret(viper.GetString("name"), viper.GetString("namespace"), *auth)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := yaml.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	if err := printer.Print(&content, secret, string(bytes.TrimSuffix(manifest, []byte("\n")))); err != nil {
+		return err
+	}
+
+	path := viper.GetString("out")
+	if path == "" {
+		_, err := content.WriteTo(os.Stdout)
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = content.WriteTo(temp)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+func init() {
+	flags := k8sSecretCmd.Flags()
+	flags.String("name", "ecr", "name of the secret")
+	flags.String("namespace", "", "namespace of the secret; omitted from the manifest when empty")
+	flags.Duration("refresh-every", 0, "keep running and render the secret again at this interval")
+	flags.String("out", "", "file to write the secret to instead of stdout, replaced atomically")
+	_ = viper.BindPFlags(flags)
+	rootCmd.AddCommand(k8sSecretCmd)
+}
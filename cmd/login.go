@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/hylandsoftware/trebuchet/internal/dockerconfig"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ErrNotECRRegistry = errors.New("registry is not an ECR registry")
+
+var loginCmd = &cobra.Command{
+	Use:   "login [REGISTRY]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Log in to ECR with the Docker CLI",
+	Example: `treb login --region us-east-1
+treb login --as arn:aws:iam::112233445566:role/PushToECR --region us-west-1
+treb login 112233445566.dkr.ecr.eu-west-1.amazonaws.com`,
+	Long: `Log in to ECR with the Docker CLI. The login command gets an authorization token for the ECR registry and
+stores it in the Docker configuration file, so that docker, docker buildx, docker compose and other tools that read
+the Docker configuration can push to and pull from the registry until the token expires after 12 hours.
+
+Registry:
+	By default the registry of the caller's account (or the registry ID flag) in the configured region is used. A
+	registry host name such as 112233445566.dkr.ecr.eu-west-1.amazonaws.com selects that registry and region instead.
+
+Docker Configuration:
+	The configuration file is read from the directory in the DOCKER_CONFIG environment variable, or ~/.docker. Other
+	settings and the credentials of other registries are left unchanged. If the Docker CLI is configured to use a
+	credential helper for the registry, the credentials in the file are ignored by it and a warning is logged.`,
+	Run: login,
+}
+
+func login(cmd *cobra.Command, args []string) {
+	ref, err := registryReference(args)
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing registry")
+	}
+
+	ecrClient, err := newECRClient(ref)
+	if err != nil {
+		log.WithError(err).Fatal("Error in creation of ECR client")
+	}
+
+	auth, err := ecrClient.GetAuthorizationToken()
+	if err != nil {
+		log.WithError(err).Fatal("Error getting authorization token for ECR")
+	}
+
+	config := loadDockerConfig()
+	registry := dockerconfig.HostName(auth.ProxyEndpoint)
+
+	if store := config.CredentialsStore(registry); store != "" {
+		log.WithFields(log.Fields{
+			"registry": registry,
+			"helper":   store,
+		}).Warn("Docker is configured to use a credential helper for the registry and will ignore the stored credentials")
+	}
+
+	if err := config.SetAuth(registry, auth.Username, auth.Password); err != nil {
+		log.WithError(err).Fatal("Error storing credentials")
+	}
+
+	if err := config.Save(); err != nil {
+		log.WithError(err).WithField("config", config.Path()).Fatal("Error writing Docker configuration")
+	}
+
+	log.WithFields(log.Fields{
+		"registry": registry,
+		"config":   config.Path(),
+	}).Info("Login succeeded")
+}
+
+// registryReference returns a reference to the registry given as the optional argument of login and logout, which
+// may be a host name or URL of an ECR registry
+func registryReference(args []string) (reference.Reference, error) {
+	if len(args) == 0 {
+		return reference.Reference{}, nil
+	}
+
+	host := dockerconfig.HostName(args[0])
+	if _, _, ok := ecr.ParseRegistryHost(host); !ok {
+		return reference.Reference{}, ErrNotECRRegistry
+	}
+
+	return reference.Reference{Registry: host}, nil
+}
+
+// loadDockerConfig reads the Docker CLI configuration file
+func loadDockerConfig() *dockerconfig.Config {
+	dir, err := dockerconfig.Dir()
+	if err != nil {
+		log.WithError(err).Fatal("Error locating Docker configuration")
+	}
+
+	config, err := dockerconfig.Load(dir)
+	if err != nil {
+		log.WithError(err).WithField("config", dir).Fatal("Error reading Docker configuration")
+	}
+
+	return config
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+}
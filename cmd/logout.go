@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/hylandsoftware/trebuchet/internal/dockerconfig"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout [REGISTRY]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Log out of ECR with the Docker CLI",
+	Example: `treb logout 112233445566.dkr.ecr.eu-west-1.amazonaws.com
+treb logout --region us-east-1`,
+	Long: `Log out of ECR with the Docker CLI. The logout command removes the credentials of the ECR registry from the
+Docker configuration file, leaving other settings and the credentials of other registries unchanged.
+
+Registry:
+	When a registry host name is given, no AWS credentials are needed. Otherwise the registry of the caller's account
+	(or the registry ID flag) in the configured region is looked up in ECR.`,
+	Run: logout,
+}
+
+func logout(cmd *cobra.Command, args []string) {
+	ref, err := registryReference(args)
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing registry")
+	}
+
+	registry := ref.Registry
+	if registry == "" {
+		ecrClient, err := newECRClient(ref)
+		if err != nil {
+			log.WithError(err).Fatal("Error in creation of ECR client")
+		}
+
+		auth, err := ecrClient.GetAuthorizationToken()
+		if err != nil {
+			log.WithError(err).Fatal("Error looking up ECR registry")
+		}
+		registry = dockerconfig.HostName(auth.ProxyEndpoint)
+	}
+
+	config := loadDockerConfig()
+	if !config.RemoveAuth(registry) {
+		log.WithField("registry", registry).Info("Not logged in to registry")
+		return
+	}
+
+	if err := config.Save(); err != nil {
+		log.WithError(err).WithField("config", config.Path()).Fatal("Error writing Docker configuration")
+	}
+
+	log.WithField("registry", registry).Info("Removed credentials of registry")
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+}
@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/archive"
 	"github.com/hylandsoftware/trebuchet/internal/docker"
 	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/output"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -13,7 +19,10 @@ var pullCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	Example: `treb pull -v --region us-east-1 helloworld:1.2.3
 treb pull -v --as arn:aws:iam::112233445566:role/PullFromECR --region us-west-1 hello/world:3.4-beta
-treb pull --strip helloworld:latest`,
+treb pull --strip helloworld:latest
+treb pull 112233445566.dkr.ecr.eu-west-1.amazonaws.com/helloworld:1.2.3
+treb pull --to-archive helloworld.tar --region us-east-1 helloworld:1.2.3
+treb pull --to-oci-layout ./helloworld --region us-east-1 helloworld:1.2.3`,
 	Short: "Pulls a Docker image from ECR",
 	Long: `Pulls a Docker image from ECR
 
@@ -21,6 +30,10 @@ Strip:
 	Strip is a boolean flag. When set, it removes all ECR-specific elements from the image name. For example, 
 	112233445566.dkr.ecr.us-east-1.amazonaws.com/hello-world:latest would be pulled as hello-world:latest.
 
+Archives:
+	When to-archive or to-oci-layout is set, the image is downloaded directly from ECR and written to a 'docker save'
+	tarball or an OCI image layout directory, without a Docker daemon.
+
 Region:
 	Region is required to be set as a flag, as an AWS environment variable (AWS_DEFAULT_REGION), or in the AWS config.
 
@@ -31,18 +44,39 @@ Amazon Resource Name (ARN):
 }
 
 func pull(cmd *cobra.Command, args []string) {
-	ecrClient, err := ecr.NewClient(viper.GetString("region"), viper.GetString("as"), viper.GetString("profile"))
+	start := time.Now()
+
+	printer, err := newPrinter()
+	if err != nil {
+		log.WithError(err).Fatal("Error in output options")
+	}
+
+	ref, err := reference.Parse(args[0])
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing image reference")
+	}
+
+	ecrClient, err := newECRClient(ref)
 	if err != nil {
 		log.WithError(err).Fatal("Error in creation of ECR client")
 	}
 
-	dockerClient, err := docker.NewClient()
+	if viper.GetString("to-archive") != "" || viper.GetString("to-oci-layout") != "" {
+		result, err := pullArchive(ecrClient, ref)
+		if err != nil {
+			log.WithError(err).WithField("image", args[0]).Fatal("Error pulling image to archive")
+		}
+		printResult(printer, result, start, "")
+		return
+	}
+
+	dockerClient, err := docker.NewClient(viper.GetString("progress"))
 	if err != nil {
 		log.WithError(err).Fatal("Error creating Docker client")
 	}
 
-	dockerImage := args[0]
-	repository := parseDockerRepositoryFromImage(dockerImage)
+	dockerImage := strippedImageName(ref)
+	repository := ref.Path
 
 	if ok, _ := ecrClient.RepositoryExists(repository); !ok {
 		log.Fatal("ECR repository does not exist")
@@ -61,11 +95,73 @@ func pull(cmd *cobra.Command, args []string) {
 	if err := docker.Pull(dockerClient, dockerImage, repositoryURI, viper.GetBool("strip"), *auth); err != nil {
 		log.WithError(err).WithField("image", dockerImage).Fatal("Error pulling Docker image")
 	}
+
+	manifest, err := ecrClient.GetImageManifest(repository, ref.Identifier())
+	if err != nil {
+		log.WithError(err).WithField("image", dockerImage).Fatal("Error retrieving digest of pulled image")
+	}
+
+	printResult(printer, pullResult(ref, repositoryURI, manifest.Digest), start, "")
+}
+
+func pullArchive(ecrClient ecr.Client, ref reference.Reference) (output.Result, error) {
+	if ok, _ := ecrClient.RepositoryExists(ref.Path); !ok {
+		return output.Result{}, errors.New("ECR repository does not exist")
+	}
+
+	repositoryURI, err := ecrClient.GetRepositoryURI(ref.Path)
+	if err != nil {
+		return output.Result{}, errors.Wrap(err, "retrieving full repository name")
+	}
+
+	image, err := archive.Fetch(ecrClient, ref.Path, ref.Identifier())
+	if err != nil {
+		return output.Result{}, errors.Wrap(err, "retrieving image manifest")
+	}
+
+	repoTag := ""
+	if ref.Tag != "" || ref.Digest == "" {
+		repoTag = reference.Reference{Path: ref.Path, Tag: ref.TagOrDefault()}.String()
+		if !viper.GetBool("strip") {
+			repoTag = repositoryURI + ":" + ref.TagOrDefault()
+		}
+	}
+
+	if path := viper.GetString("to-archive"); path != "" {
+		log.WithField("archive", path).Info("Writing image to archive")
+		err = archive.WriteTarball(image, path, repoTag)
+	} else {
+		path := viper.GetString("to-oci-layout")
+		log.WithField("layout", path).Info("Writing image to OCI layout")
+		err = archive.WriteOCILayout(image, path, ref.Tag)
+	}
+	if err != nil {
+		return output.Result{}, err
+	}
+
+	return pullResult(ref, repositoryURI, image.Digest()), nil
+}
+
+// pullResult returns the result of pulling 'ref' from the repository
+func pullResult(ref reference.Reference, repositoryURI string, digest string) output.Result {
+	result := output.Result{RepositoryURI: repositoryURI, Digest: digest}
+	if ref.Tag != "" || ref.Digest == "" {
+		result.Tags = []string{ref.TagOrDefault()}
+	}
+	return result
+}
+
+// strippedImageName returns the image name without any registry, which identifies the image to pull and, without its
+// digest, is how the image is tagged locally when stripping ECR-specific elements
+func strippedImageName(ref reference.Reference) string {
+	return reference.Reference{Path: ref.Path, Tag: ref.Tag, Digest: ref.Digest}.String()
 }
 
 func init() {
 	flags := pullCmd.Flags()
 	flags.BoolP("strip", "s", true, "strip the image name of ECR-specific elements")
+	flags.String("to-archive", "", "write the image to a 'docker save' tarball instead of the Docker daemon")
+	flags.String("to-oci-layout", "", "write the image to an OCI image layout directory instead of the Docker daemon")
 	_ = viper.BindPFlags(flags)
 	rootCmd.AddCommand(pullCmd)
 }
\ No newline at end of file
@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"io/ioutil"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/archive"
 	"github.com/hylandsoftware/trebuchet/internal/docker"
 	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/output"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
+	"github.com/hylandsoftware/trebuchet/internal/semver"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-
-	"strings"
 )
 
 var pushCmd = &cobra.Command{
@@ -16,7 +22,14 @@ var pushCmd = &cobra.Command{
 	Aliases: []string{"launch", "fling"},
 	Example: `treb push -v --region us-east-1 helloworld:1.2.3
 treb launch -v --as arn:aws:iam::112233445566:role/PushToECR --region us-west-1 hello/world:3.4-beta
-treb push helloworld:latest`,
+treb push helloworld:latest
+treb push 112233445566.dkr.ecr.eu-west-1.amazonaws.com/helloworld:1.2.3
+treb push helloworld:build --tag 1.2.3 --tag 1.2 --tag latest
+treb push --semver-expand --semver-latest helloworld:1.4.2
+treb push --skip-existing helloworld:1.2.3
+treb push --digest-file digest.txt helloworld:1.2.3
+treb push --from-archive image.tar --region us-east-1 helloworld:1.2.3
+treb push --from-oci-layout ./image --region us-east-1 helloworld:1.2.3`,
 	Short: "Pushes a Docker image into ECR",
 	Long: `Pushes a Docker image into ECR
 
@@ -27,18 +40,67 @@ Amazon Resource Name (ARN):
 	Passing in a valid ARN allows trebuchet to assume a role to perform actions within AWS. A typical use-case for this
 	would be a service account to use in a software pipeline to push images to ECR.
 
+Semantic Versions:
+	When semver-expand is set, MAJOR.MINOR.PATCH tags are also pushed as MAJOR.MINOR and MAJOR, unless a higher
+	release already holds them, and as latest when semver-latest is set.
+
+Digest:
+	The digest of the pushed image is printed, and written to digest-file when it is set.
+
+Skipping Existing Images:
+	When skip-existing is set, tags that already refer to the same image in ECR are not pushed again.
+
+Archives:
+	When from-archive or from-oci-layout is set, the image is read from a 'docker save' tarball or an OCI image layout
+	directory and uploaded directly to ECR, without a Docker daemon.
+
 Aliases:
 	trebuchet push can also be used as 'treb launch' or 'treb fling' for a more authentic experience.`,
 	Run: push,
 }
 
 func push(cmd *cobra.Command, args []string) {
-	ecrClient, err := ecr.NewClient(viper.GetString("region"), viper.GetString("as"), viper.GetString("profile"))
+	start := time.Now()
+
+	printer, err := newPrinter()
+	if err != nil {
+		log.WithError(err).Fatal("Error in output options")
+	}
+
+	ref, err := reference.Parse(args[0])
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing image reference")
+	}
+
+	tags := viper.GetStringSlice("tag")
+	for _, tag := range tags {
+		if err := reference.ValidateTag(tag); err != nil {
+			log.WithError(err).Fatal("Error validating tag")
+		}
+	}
+	if len(tags) == 0 {
+		// Defaulting to latest would silently move it to an image that was only referenced by its digest
+		if ref.Tag == "" && ref.Digest != "" {
+			log.WithField("image", args[0]).Fatal("A tag flag is required to push an image referenced by its digest")
+		}
+		tags = []string{ref.TagOrDefault()}
+	}
+
+	ecrClient, err := newECRClient(ref)
 	if err != nil {
 		log.WithError(err).Fatal("Error in creation of ECR client")
 	}
 
-	dockerClient, err := docker.NewClient()
+	if viper.GetString("from-archive") != "" || viper.GetString("from-oci-layout") != "" {
+		result, err := pushArchive(ecrClient, ref, tags)
+		if err != nil {
+			log.WithError(err).WithField("image", args[0]).Fatal("Error pushing image from archive")
+		}
+		printResult(printer, result, start, result.Digest)
+		return
+	}
+
+	dockerClient, err := docker.NewClient(viper.GetString("progress"))
 	if err != nil {
 		log.WithError(err).Fatal("Error creating Docker client")
 	}
@@ -48,30 +110,163 @@ func push(cmd *cobra.Command, args []string) {
 	}
 
 	dockerImage := args[0]
-	repository := parseDockerRepositoryFromImage(dockerImage)
+	repository := ref.Path
 
 	repositoryURI, err := ecr.SetupRepository(ecrClient, repository)
 	if err != nil {
 		log.WithError(err).WithField("image", repository).Fatal("Error setting up repository for image")
 	}
 
-	auth, err := ecrClient.GetAuthorizationToken()
+	tags, err = expandSemverTags(ecrClient, repository, tags)
+	if err != nil {
+		log.WithError(err).WithField("image", repository).Fatal("Error expanding semantic version tags")
+	}
+
+	pushed := tags
+	if viper.GetBool("skip-existing") {
+		pushed, err = ecr.OutdatedTags(ecrClient, repository, tags, func(remote *ecr.ImageManifest) (bool, error) {
+			return docker.IsUpToDate(dockerClient, dockerImage, repositoryURI, remote)
+		})
+		if err != nil {
+			log.WithError(err).WithField("image", dockerImage).Fatal("Error comparing image with ECR")
+		}
+	}
+
+	var digest string
+	if len(pushed) == 0 {
+		log.WithField("image", dockerImage).Info("Image is up to date")
+	} else {
+		auth, err := ecrClient.GetAuthorizationToken()
+		if err != nil {
+			log.WithError(err).Fatal("Error getting authorization token for ECR")
+		}
+
+		digest, err = docker.TagAndPush(dockerClient, dockerImage, repositoryURI, pushed, *auth)
+		if err != nil {
+			log.WithError(err).WithField("image", dockerImage).Fatal("Error pushing Docker image")
+		}
+	}
+
+	digest, err = verifyDigest(ecrClient, repository, tags, pushed, digest)
+	if err != nil {
+		log.WithError(err).WithField("image", dockerImage).Fatal("Error verifying pushed image")
+	}
+
+	result := output.Result{RepositoryURI: repositoryURI, Tags: tags, Digest: digest}
+	printResult(printer, result, start, digest)
+}
+
+func pushArchive(ecrClient ecr.Client, ref reference.Reference, tags []string) (output.Result, error) {
+	var image *archive.Image
+	var err error
+	if path := viper.GetString("from-archive"); path != "" {
+		image, err = archive.ReadTarball(path, ref.String())
+	} else {
+		image, err = archive.ReadOCILayout(viper.GetString("from-oci-layout"), ref.String())
+	}
+	if err != nil {
+		return output.Result{}, errors.Wrap(err, "reading image from archive")
+	}
+	defer image.Close()
+
+	repositoryURI, err := ecr.SetupRepository(ecrClient, ref.Path)
 	if err != nil {
-		log.WithError(err).Fatal("Error getting authorization token for ECR")
+		return output.Result{}, errors.Wrap(err, "setting up repository for image")
+	}
+
+	tags, err = expandSemverTags(ecrClient, ref.Path, tags)
+	if err != nil {
+		return output.Result{}, errors.Wrap(err, "expanding semantic version tags")
+	}
+
+	pushed := tags
+	if viper.GetBool("skip-existing") {
+		pushed, err = ecr.OutdatedTags(ecrClient, ref.Path, tags, func(remote *ecr.ImageManifest) (bool, error) {
+			return archive.IsUpToDate(image, remote)
+		})
+		if err != nil {
+			return output.Result{}, errors.Wrap(err, "comparing image with ECR")
+		}
+	}
+
+	var digest string
+	if len(pushed) == 0 {
+		log.WithField("image", ref.String()).Info("Image is up to date")
+	} else if digest, err = archive.Push(ecrClient, image, ref.Path, pushed); err != nil {
+		return output.Result{}, err
 	}
 
-	if err := docker.TagAndPush(dockerClient, dockerImage, repositoryURI, *auth); err != nil {
-		log.WithError(err).WithField("image", dockerImage).Fatal("Error pushing Docker image")
+	digest, err = verifyDigest(ecrClient, ref.Path, tags, pushed, digest)
+	if err != nil {
+		return output.Result{}, errors.Wrap(err, "verifying pushed image")
 	}
+
+	return output.Result{RepositoryURI: repositoryURI, Tags: tags, Digest: digest}, nil
 }
 
-func parseDockerRepositoryFromImage(image string) string {
-	if strings.Contains(image, ":") {
-		return strings.Split(image, ":")[0]
+// verifyDigest checks that every pushed tag refers to 'digest', then writes the digest to the digest file when one is
+// set. When every tag was already up to date, the digest of the first tag is returned instead.
+func verifyDigest(ecrClient ecr.Client, repository string, tags []string, pushed []string, digest string) (string, error) {
+	if len(pushed) == 0 {
+		pushed = tags[:1]
+	}
+
+	digest, err := ecr.VerifyDigest(ecrClient, repository, pushed, digest)
+	if err != nil {
+		return "", err
 	}
-	return image
+
+	log.WithField("digest", digest).Info("Verified pushed image digest")
+
+	if path := viper.GetString("digest-file"); path != "" {
+		if err := ioutil.WriteFile(path, []byte(digest), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return digest, nil
+}
+
+// expandSemverTags adds the floating tags of every semantic version in 'tags' when semver-expand is set, based on the
+// tags that already exist in the repository
+func expandSemverTags(ecrClient ecr.Client, repository string, tags []string) ([]string, error) {
+	if !viper.GetBool("semver-expand") {
+		return tags, nil
+	}
+
+	existing, err := ecrClient.ListImageTags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	result := append([]string{}, tags...)
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		seen[tag] = true
+	}
+
+	for _, tag := range tags {
+		for _, floating := range semver.Expand(tag, existing, viper.GetBool("semver-latest")) {
+			if !seen[floating] {
+				seen[floating] = true
+				result = append(result, floating)
+			}
+		}
+	}
+
+	log.WithField("tags", result).Info("Expanded semantic version tags")
+	return result, nil
 }
 
 func init() {
+	flags := pushCmd.Flags()
+	flags.StringSlice("tag", nil, "tag to push the image with in ECR instead of the tag in NAME; may be repeated")
+	flags.Bool("semver-expand", false, "also push MAJOR.MINOR and MAJOR tags for semantic version tags")
+	flags.Bool("semver-latest", false, "with semver-expand, also push the latest tag for the highest version")
+	flags.String("digest-file", "", "write the digest of the pushed image to a file")
+	flags.Bool("skip-existing", false, "skip tags that already refer to the same image in ECR")
+	flags.String("from-archive", "", "push an image from a 'docker save' tarball instead of the Docker daemon")
+	flags.String("from-oci-layout", "", "push an image from an OCI image layout directory instead of the Docker daemon")
+	_ = viper.BindPFlags(flags)
 	rootCmd.AddCommand(pushCmd)
 }
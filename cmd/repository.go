@@ -1,12 +1,12 @@
 package cmd
 
 import (
-	"fmt"
+	"time"
 
-	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/output"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var repositoryCmd = &cobra.Command{
@@ -15,7 +15,8 @@ var repositoryCmd = &cobra.Command{
 	Aliases: []string{"repo"},
 	Example: `treb repository helloworld --region us-east-1
 treb repo some/project/helloworld --region us-west-2 --as arn:aws:iam::112233445566
-treb repo my/repository`,
+treb repo my/repository
+treb repo 112233445566.dkr.ecr.eu-west-1.amazonaws.com/my/repository`,
 	Short: "Get the full URL of a repository in Amazon ECR",
 	Long: `Get the full URL of a repository in Amazon ECR. The repository command will lookup the repository passed in
 to see if it exists in Amazon ECR and return it to be used for deployment or reference purposes. 
@@ -31,19 +32,29 @@ Amazon Resource Name (ARN):
 }
 
 func repository(cmd *cobra.Command, args []string) {
-	log.SetLevel(log.ErrorLevel)
+	start := time.Now()
 
-	ecrClient, err := ecr.NewClient(viper.GetString("region"), viper.GetString("as"), viper.GetString("profile"))
+	printer, err := newPrinter()
+	if err != nil {
+		log.WithError(err).Fatal("Error in output options")
+	}
+
+	ref, err := reference.Parse(args[0])
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing repository name")
+	}
+
+	ecrClient, err := newECRClient(ref)
 	if err != nil {
 		log.WithError(err).Fatal("Error in creation of ECR client")
 	}
 
-	repositoryURI, err := ecrClient.GetRepositoryURI(args[0])
+	repositoryURI, err := ecrClient.GetRepositoryURI(ref.Path)
 	if err != nil {
 		log.WithError(err).Fatal("Error getting repository URI")
 	}
 
-	fmt.Println(repositoryURI)
+	printResult(printer, output.Result{RepositoryURI: repositoryURI}, start, repositoryURI)
 }
 
 func init() {
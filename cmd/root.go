@@ -1,15 +1,31 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	"github.com/hylandsoftware/trebuchet/internal/config"
+	"github.com/hylandsoftware/trebuchet/internal/docker"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/logging"
+	"github.com/hylandsoftware/trebuchet/internal/output"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
+	"github.com/hylandsoftware/trebuchet/internal/sts"
 	"github.com/mattn/go-colorable"
+	"github.com/mattn/go-isatty"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var ErrMFATokenRequired = errors.New("mfa-token is required when stdin is not a terminal")
+
 var (
 	version string
 	rootCmd = &cobra.Command{
@@ -29,14 +45,22 @@ Authentication:
 	If the AWS credentials or config file are in non-standard locations (~/.aws), the AWS_SHARED_CREDENTIALS_FILE
 	or AWS_CONFIG_FILE environment variables can be set to point to the location of those files.
 
+	Profiles using SSO or credential_process, web identity tokens and chains of roles to assume are supported as
+	well; see the flags below for the options of assumed roles.
+
 Verbose:
 	The verbose flag is a global flag that enables debug logging. The default is false.`,
 	}
 )
 
 func Execute() {
+	// When run as docker-credential-treb, the arguments are those of a credential helper
+	if strings.HasPrefix(filepath.Base(os.Args[0]), credentialHelperPrefix) {
+		rootCmd.SetArgs(append([]string{credentialHelperCmd.Name()}, os.Args[1:]...))
+	}
+
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -45,21 +69,180 @@ func init() {
 	flags := rootCmd.PersistentFlags()
 
 	flags.BoolP("verbose", "v", false, "Enables verbose logging.")
-	flags.StringP("as", "a", "",
-		"Amazon Resource Name (ARN) specifying the role to be assumed.")
+	flags.StringSliceP("as", "a", nil,
+		"Amazon Resource Name (ARN) specifying the role to be assumed. May be repeated to assume roles in sequence.")
+	flags.String("web-identity-token-file", "",
+		"File with a web identity token to assume the role with. Supported as flag or AWS_WEB_IDENTITY_TOKEN_FILE environment variable.")
+	flags.String("role-session-name", "",
+		"Session name of assumed roles. Defaults to a name derived from the CI job, such as BUILD_TAG or GITHUB_RUN_ID.")
+	flags.String("external-id", "",
+		"External ID required to assume the role.")
+	flags.Duration("role-duration", 0,
+		"How long the credentials of assumed roles are valid for. Defaults to one hour.")
+	flags.Duration("role-refresh-window", 0,
+		"How long before their expiry the credentials of assumed roles are refreshed. Defaults to 5 minutes.")
+	flags.StringToString("session-tag", nil,
+		"Session tag to assume the role with, as key=value. May be repeated.")
+	flags.StringSlice("transitive-tag-key", nil,
+		"Key of a session tag that is kept when assuming further roles. May be repeated.")
+	flags.String("source-identity", "",
+		"Source identity to assume the role with, recorded in CloudTrail.")
+	flags.String("mfa-serial", "",
+		"Serial number or ARN of the MFA device required to assume the role.")
+	flags.String("mfa-token", "",
+		"Current code of the MFA device. Asked for on the terminal when not set. Roles assumed with MFA are not refreshed.")
 	flags.StringP("region", "r", "",
-		"AWS region to be used. Supported as flag, AWS_DEFAULT_REGION environment variable or AWS Config File.")
+		"AWS region to be used. Supported as flag, AWS_DEFAULT_REGION environment variable or AWS Config File. Taken from the URI when a full ECR URI is given.")
 	flags.StringP("profile", "p", "",
 		"AWS Shared Credentials profile to be used.")
+	flags.String("registry-id", "",
+		"AWS account ID of the ECR registry to use. Defaults to the registry of the caller's account.")
+	flags.String("config", "",
+		"Configuration file to read global flags from. Defaults to $HOME/.trebuchet.yaml.")
+	flags.StringP("output", "o", output.FormatText,
+		"Output format of the result: text, json or yaml.")
+	flags.String("format", "",
+		"Go template used to print the result instead of the output format.")
+	flags.String("progress", docker.ProgressAuto,
+		"Progress output of pushes and pulls: auto, tty, plain, json or none.")
+	flags.String("log-format", logging.FormatText,
+		"Format of log records: text or json.")
+	flags.String("log-file", "",
+		"File to also append log records to.")
+	flags.Bool("no-cache", false,
+		"Disables caching of ECR authorization tokens and assumed role credentials in the user's cache directory.")
 	_ = viper.BindPFlags(flags)
 
-	cobra.OnInitialize(initLogrus)
+	cobra.OnInitialize(initConfig, initLogrus)
+}
+
+func initConfig() {
+	if err := config.Load(viper.GetViper(), viper.GetString("config")); err != nil {
+		log.WithError(err).Fatal("Error reading configuration file")
+	}
+}
+
+// newECRClient creates an ECR client using the global flags. When 'ref' is a full ECR URI, the region and registry ID
+// of the registry in the URI are used, and an explicitly set registry ID must match it.
+func newECRClient(ref reference.Reference) (ecr.Client, error) {
+	options := ecr.Options{
+		Region:      viper.GetString("region"),
+		AssumeRoles: assumeRoles(),
+		Profile:     viper.GetString("profile"),
+		RegistryID:  viper.GetString("registry-id"),
+
+		WebIdentityTokenFile: viper.GetString("web-identity-token-file"),
+		RoleOptions: sts.AssumeRoleOptions{
+			SessionName: viper.GetString("role-session-name"),
+			ExternalID:  viper.GetString("external-id"),
+			Duration:    viper.GetDuration("role-duration"),
+			MFASerial:   viper.GetString("mfa-serial"),
+			MFAToken:    mfaToken,
+
+			Tags:              viper.GetStringMapString("session-tag"),
+			TransitiveTagKeys: viper.GetStringSlice("transitive-tag-key"),
+			SourceIdentity:    viper.GetString("source-identity"),
+			RefreshWindow:     viper.GetDuration("role-refresh-window"),
+		},
+	}
+
+	if !viper.GetBool("no-cache") {
+		if dir, err := cache.DefaultDir(); err != nil {
+			log.WithError(err).Debug("Unable to locate cache directory; credentials will not be cached")
+		} else {
+			options.Cache = cache.New(dir)
+		}
+	}
+
+	if err := options.UseRegistryHost(ref.Registry); err != nil {
+		return nil, err
+	}
+
+	return ecr.NewClient(options)
+}
+
+// assumeRoles returns the roles of the as flag, which may be repeated or given as a comma-separated list, including
+// in the configuration file
+func assumeRoles() []string {
+	var roles []string
+	for _, value := range viper.GetStringSlice("as") {
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return roles
+}
+
+// mfaToken returns the MFA code of the mfa-token flag, or asks for it when stdin is a terminal
+func mfaToken() (string, error) {
+	if token := viper.GetString("mfa-token"); token != "" {
+		return token, nil
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return "", ErrMFATokenRequired
+	}
+
+	fmt.Fprintf(os.Stderr, "MFA code for %s: ", viper.GetString("mfa-serial"))
+	token, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// newPrinter returns the printer selected by the output and format flags
+func newPrinter() (output.Printer, error) {
+	printer := output.Printer{
+		Format:   viper.GetString("output"),
+		Template: viper.GetString("format"),
+	}
+
+	return printer, printer.Validate()
+}
+
+// printResult prints the result of a command, filling in the region and registry ID from its repository URI and the
+// time elapsed since 'start'. In text format only 'text' is printed.
+func printResult(printer output.Printer, result output.Result, start time.Time, text string) {
+	if ref, err := reference.Parse(result.RepositoryURI); err == nil {
+		result.RegistryID, result.Region, _ = ecr.ParseRegistryHost(ref.Registry)
+	}
+	result.SetDuration(start)
+
+	if err := printer.Print(os.Stdout, result, text); err != nil {
+		log.WithError(err).Fatal("Error printing result")
+	}
 }
 
 func initLogrus() {
-	log.SetFormatter(&log.TextFormatter{ForceColors: true})
-	log.SetOutput(colorable.NewColorableStdout())
+	formatter, err := logging.NewFormatter(viper.GetString("log-format"), isatty.IsTerminal(os.Stderr.Fd()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error configuring logging:", err)
+		os.Exit(1)
+	}
+
+	log.SetFormatter(formatter)
+	log.SetOutput(colorable.NewColorableStderr())
 	if viper.GetBool("verbose") {
 		log.SetLevel(log.DebugLevel)
 	}
+
+	if logFile := viper.GetString("log-file"); logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening log file:", err)
+			os.Exit(1)
+		}
+
+		hook, err := logging.NewFileHook(file, viper.GetString("log-format"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error configuring logging:", err)
+			os.Exit(1)
+		}
+		log.AddHook(hook)
+	}
 }
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	"github.com/hylandsoftware/trebuchet/internal/proxy"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// shutdownTimeout is how long requests in flight are given to complete when the proxy is stopped
+const shutdownTimeout = 30 * time.Second
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [REGISTRY]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Serves a local registry that forwards to ECR without credentials",
+	Example: `treb serve --region us-east-1
+treb serve --listen 127.0.0.1:5000 112233445566.dkr.ecr.eu-west-1.amazonaws.com
+docker pull localhost:5000/app:1.0`,
+	Long: `Serves a plain Docker registry (API v2) over HTTP that forwards every request to an ECR registry, adding an ECR
+authorization token. Tools that cannot use credential helpers, such as older containerd configurations or image
+scanners, can then pull from and push to ECR through localhost:5000/app:1.0 without any credentials.
+
+Registry:
+	By default the registry of the caller's account (or the registry ID flag) in the configured region is used. A
+	registry host name such as 112233445566.dkr.ecr.eu-west-1.amazonaws.com selects that registry and region instead.
+
+Authorization:
+	A new authorization token is requested 30 minutes before the current one expires, so the proxy can run
+	indefinitely. Anyone who can connect to the listen address can use the registry with the caller's permissions,
+	which is why it only listens on the loopback interface by default.
+
+Blobs:
+	ECR redirects layer downloads to S3, and clients follow these redirects directly, so they still need access to
+	S3 but not to credentials.
+
+Blob Cache:
+	When blob-cache-size is set, for example to 20GB, layers and image configurations are downloaded by the proxy
+	and kept in blob-cache-dir, so that repeated pulls of the same layers on a host are served locally. When the
+	cache exceeds its size, the least recently used blobs are removed. Blobs are verified against their digest before
+	they are cached, and the cache is kept when the proxy is restarted. Several proxies on a host, such as those of
+	parallel CI jobs, can share a directory, in which case the size applies to all of them together.`,
+	Run: serve,
+}
+
+func serve(cmd *cobra.Command, args []string) {
+	ref, err := registryReference(args)
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing registry")
+	}
+
+	// The proxy refreshes tokens itself, a cached token may already be close to its expiry
+	viper.Set("no-cache", true)
+
+	address := viper.GetString("listen")
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			log.WithField("listen", address).Warn("Registry is reachable from other hosts and uses your credentials")
+		}
+	}
+
+	blobs, err := newBlobCache()
+	if err != nil {
+		log.WithError(err).Fatal("Error creating blob cache")
+	}
+	if blobs != nil {
+		defer blobs.Close()
+	}
+
+	ecrClient, err := newECRClient(ref)
+	if err != nil {
+		log.WithError(err).Fatal("Error in creation of ECR client")
+	}
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: proxy.New(ecrClient.GetAuthorizationToken, blobs),
+	}
+
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(stopped)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("Error stopping registry")
+		}
+	}()
+
+	log.WithField("listen", address).Info("Serving registry")
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Fatal("Error serving registry")
+	}
+
+	<-stopped
+	log.Info("Stopped serving registry")
+}
+
+// newBlobCache creates the blob cache of the proxy, or returns nil if blobs are not cached
+func newBlobCache() (*proxy.BlobCache, error) {
+	size := viper.GetString("blob-cache-size")
+	if size == "" {
+		return nil, nil
+	}
+
+	maxSize, err := units.FromHumanSize(size)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := viper.GetString("blob-cache-dir")
+	if dir == "" {
+		cacheDir, err := cache.DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cacheDir, "blobs")
+	}
+
+	log.WithFields(log.Fields{"dir": dir, "size": units.HumanSize(float64(maxSize))}).Info("Caching blobs")
+	return proxy.NewBlobCache(dir, maxSize)
+}
+
+func init() {
+	flags := serveCmd.Flags()
+	flags.String("listen", "127.0.0.1:5000", "address to serve the registry on")
+	flags.String("blob-cache-size", "", "maximum size of the blob cache, such as 20GB; blobs are not cached when empty")
+	flags.String("blob-cache-dir", "", "directory of the blob cache (default: blobs in the user cache directory)")
+	_ = viper.BindPFlags(flags)
+	rootCmd.AddCommand(serveCmd)
+}
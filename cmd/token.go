@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/dockerconfig"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token [REGISTRY]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Prints an ECR authorization token for other registry clients",
+	Example: `treb token --region us-east-1 | docker login --username AWS --password-stdin 112233445566.dkr.ecr.us-east-1.amazonaws.com
+treb token 112233445566.dkr.ecr.eu-west-1.amazonaws.com | helm registry login --username AWS --password-stdin 112233445566.dkr.ecr.eu-west-1.amazonaws.com
+skopeo copy --dest-creds "$(treb token --with-username --region us-east-1)" docker-archive:image.tar docker://...
+treb token --output json --region us-east-1`,
+	Long: `Prints an ECR authorization token for other registry clients, such as docker login, Helm, oras or skopeo. By
+default only the password is printed. The username for ECR registries is always AWS.
+
+Registry:
+	By default the registry of the caller's account (or the registry ID flag) in the configured region is used. A
+	registry host name such as 112233445566.dkr.ecr.eu-west-1.amazonaws.com selects that registry and region instead.
+
+Output:
+	The with-username flag prints USERNAME:PASSWORD instead of only the password. With the output flag set to json or
+	yaml, an object with the registry, username, password and expiry of the token is printed.`,
+	Run: token,
+}
+
+// tokenResult is the result of the token command
+type tokenResult struct {
+	Registry  string    `json:"registry" yaml:"registry"`
+	Username  string    `json:"username" yaml:"username"`
+	Password  string    `json:"password" yaml:"password"`
+	ExpiresAt time.Time `json:"expiresAt" yaml:"expiresAt"`
+}
+
+func token(cmd *cobra.Command, args []string) {
+	printer, err := newPrinter()
+	if err != nil {
+		log.WithError(err).Fatal("Error in output options")
+	}
+
+	ref, err := registryReference(args)
+	if err != nil {
+		log.WithError(err).Fatal("Error parsing registry")
+	}
+
+	ecrClient, err := newECRClient(ref)
+	if err != nil {
+		log.WithError(err).Fatal("Error in creation of ECR client")
+	}
+
+	auth, err := ecrClient.GetAuthorizationToken()
+	if err != nil {
+		log.WithError(err).Fatal("Error getting authorization token for ECR")
+	}
+
+	text := auth.Password
+	if viper.GetBool("with-username") {
+		text = auth.Username + ":" + auth.Password
+	}
+
+	result := tokenResult{
+		Registry:  dockerconfig.HostName(auth.ProxyEndpoint),
+		Username:  auth.Username,
+		Password:  auth.Password,
+		ExpiresAt: auth.ExpiresAt,
+	}
+
+	if err := printer.Print(os.Stdout, result, text); err != nil {
+		log.WithError(err).Fatal("Error printing result")
+	}
+}
+
+func init() {
+	flags := tokenCmd.Flags()
+	flags.Bool("with-username", false, "print USERNAME:PASSWORD instead of only the password")
+	_ = viper.BindPFlags(flags)
+	rootCmd.AddCommand(tokenCmd)
+}
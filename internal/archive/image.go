@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig   = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+var (
+	ErrNoImageInArchive       = errors.New("no image found in archive")
+	ErrAmbiguousImage         = errors.New("archive contains more than one image and none match the requested tag")
+	ErrUnsupportedMediaType   = errors.New("unsupported manifest media type")
+	ErrDigestMismatch         = errors.New("content does not match its digest")
+	ErrMissingArchiveManifest = errors.New("archive does not contain a manifest.json")
+	ErrDownloadStalled        = errors.New("download stalled")
+)
+
+// Descriptor references a blob by its media type, digest and size, as found in image manifests
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is the subset of a Docker v2 schema 2 or OCI image manifest that trebuchet needs
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Blob is a config or layer blob of an image that can be opened for reading
+type Blob struct {
+	Descriptor
+	open func() (io.ReadCloser, error)
+}
+
+// Open returns a reader for the content of the blob
+func (b Blob) Open() (io.ReadCloser, error) {
+	return b.open()
+}
+
+// Image is an image read from disk that can be pushed to ECR without a Docker daemon
+type Image struct {
+	Manifest  []byte
+	MediaType string
+	Config    Blob
+	Layers    []Blob
+	cleanup   func() error
+}
+
+// Close releases any temporary files that were created while reading the image
+func (i *Image) Close() error {
+	if i.cleanup == nil {
+		return nil
+	}
+	return i.cleanup()
+}
+
+// Digest returns the digest of the image manifest
+func (i *Image) Digest() string {
+	return digestOf(i.Manifest)
+}
+
+// Blobs returns the config blob followed by the layers of the image
+func (i *Image) Blobs() []Blob {
+	return append([]Blob{i.Config}, i.Layers...)
+}
+
+// Push uploads every blob of the image that does not already exist in the repository, then puts the manifest under
+// each of the given tags. The digest of the pushed manifest is returned.
+func Push(ecrClient ecr.Client, image *Image, repository string, tags []string) (string, error) {
+	for _, blob := range image.Blobs() {
+		exists, err := ecrClient.LayerExists(repository, blob.Digest)
+		if err != nil {
+			return "", err
+		}
+
+		if exists {
+			log.WithField("component", "archive").WithField("digest", blob.Digest).Info("Layer already exists")
+			continue
+		}
+
+		if err := uploadBlob(ecrClient, repository, blob); err != nil {
+			return "", err
+		}
+	}
+
+	var digest string
+	for _, tag := range tags {
+		var err error
+		digest, err = ecrClient.PutImage(repository, tag, string(image.Manifest), image.MediaType)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return digest, nil
+}
+
+// IsUpToDate reports whether the image in ECR described by 'remote' is the same as 'image', either because their
+// manifests are identical or because they share a config digest
+func IsUpToDate(image *Image, remote *ecr.ImageManifest) (bool, error) {
+	if remote.Digest == image.Digest() {
+		return true, nil
+	}
+
+	configDigest, err := remote.ConfigDigest()
+	if err != nil {
+		return false, err
+	}
+
+	return configDigest == image.Config.Digest, nil
+}
+
+func uploadBlob(ecrClient ecr.Client, repository string, blob Blob) error {
+	reader, err := blob.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return ecrClient.UploadLayer(repository, blob.Digest, reader)
+}
+
+func fileBlob(descriptor Descriptor, path string) Blob {
+	return Blob{
+		Descriptor: descriptor,
+		open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}
+}
+
+func digestOf(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
@@ -0,0 +1,143 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockECRClient struct {
+	mock.Mock
+}
+
+func (m *mockECRClient) RepositoryExists(repository string) (bool, error) {
+	args := m.Called(repository)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockECRClient) CreateRepository(repository string) error {
+	args := m.Called(repository)
+	return args.Error(0)
+}
+
+func (m *mockECRClient) GetRepositoryURI(repository string) (string, error) {
+	args := m.Called(repository)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockECRClient) GetAuthorizationToken() (*ecr.RegistryAuth, error) {
+	args := m.Called()
+	return args.Get(0).(*ecr.RegistryAuth), args.Error(1)
+}
+
+func (m *mockECRClient) LayerExists(repository string, digest string) (bool, error) {
+	args := m.Called(repository, digest)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockECRClient) UploadLayer(repository string, digest string, layer io.Reader) error {
+	content, _ := ioutil.ReadAll(layer)
+	args := m.Called(repository, digest, string(content))
+	return args.Error(0)
+}
+
+func (m *mockECRClient) PutImage(repository string, tag string, manifest string, mediaType string) (string, error) {
+	args := m.Called(repository, tag, manifest, mediaType)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockECRClient) GetImageManifest(repository string, reference string) (*ecr.ImageManifest, error) {
+	args := m.Called(repository, reference)
+	manifest, _ := args.Get(0).(*ecr.ImageManifest)
+	return manifest, args.Error(1)
+}
+
+func (m *mockECRClient) GetLayerURL(repository string, digest string) (string, error) {
+	args := m.Called(repository, digest)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockECRClient) ListImageTags(repository string) ([]string, error) {
+	args := m.Called(repository)
+	tags, _ := args.Get(0).([]string)
+	return tags, args.Error(1)
+}
+
+func TestArchive_Push_UploadsMissingBlobsAndPutsImage(t *testing.T) {
+	image := &Image{
+		Manifest:  []byte("manifest"),
+		MediaType: MediaTypeDockerManifest,
+		Config:    stringBlob("sha256:config", "config"),
+		Layers:    []Blob{stringBlob("sha256:existing", "existing"), stringBlob("sha256:missing", "missing")},
+	}
+	m := &mockECRClient{}
+	m.On("LayerExists", "repo", "sha256:config").Return(false, nil)
+	m.On("LayerExists", "repo", "sha256:existing").Return(true, nil)
+	m.On("LayerExists", "repo", "sha256:missing").Return(false, nil)
+	m.On("UploadLayer", "repo", "sha256:config", "config").Return(nil)
+	m.On("UploadLayer", "repo", "sha256:missing", "missing").Return(nil)
+	m.On("PutImage", "repo", "1.2.3", "manifest", MediaTypeDockerManifest).Return("sha256:digest", nil)
+	m.On("PutImage", "repo", "latest", "manifest", MediaTypeDockerManifest).Return("sha256:digest", nil)
+
+	result, err := Push(m, image, "repo", []string{"1.2.3", "latest"})
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:digest", result)
+	m.AssertNotCalled(t, "UploadLayer", "repo", "sha256:existing", mock.Anything)
+	m.AssertExpectations(t)
+}
+
+func TestArchive_Push_ReturnsErrorOnUploadError(t *testing.T) {
+	image := &Image{Config: stringBlob("sha256:config", "config")}
+	m := &mockECRClient{}
+	m.On("LayerExists", mock.Anything, mock.Anything).Return(false, nil)
+	m.On("UploadLayer", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("error"))
+
+	_, err := Push(m, image, "repo", []string{"1.2.3"})
+
+	require.EqualError(t, err, "error")
+	m.AssertNotCalled(t, "PutImage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestArchive_IsUpToDate_MatchesManifestDigest(t *testing.T) {
+	image := testImage()
+
+	result, err := IsUpToDate(image, &ecr.ImageManifest{Digest: digestOf(image.Manifest), Manifest: string(image.Manifest)})
+
+	require.NoError(t, err)
+	require.True(t, result)
+}
+
+func TestArchive_IsUpToDate_MatchesConfigDigest(t *testing.T) {
+	image := testImage()
+	manifest := `{"schemaVersion":2,"config":{"digest":"` + image.Config.Digest + `"}}`
+
+	result, err := IsUpToDate(image, &ecr.ImageManifest{Digest: "sha256:other", Manifest: manifest})
+
+	require.NoError(t, err)
+	require.True(t, result)
+}
+
+func TestArchive_IsUpToDate_ReturnsFalseForDifferentImage(t *testing.T) {
+	manifest := `{"schemaVersion":2,"config":{"digest":"sha256:other"}}`
+
+	result, err := IsUpToDate(testImage(), &ecr.ImageManifest{Digest: "sha256:other", Manifest: manifest})
+
+	require.NoError(t, err)
+	require.False(t, result)
+}
+
+func stringBlob(digest string, content string) Blob {
+	return Blob{
+		Descriptor: Descriptor{Digest: digest, Size: int64(len(content))},
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+}
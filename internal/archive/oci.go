@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociLayoutFile        = "oci-layout"
+	ociIndexFile         = "index.json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+var ErrNotOCILayout = errors.New("directory is not an OCI image layout")
+
+type ociIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// ReadOCILayout reads an image from an OCI image layout directory. When the layout contains more than one manifest,
+// the one whose ref name annotation matches 'image' (or just its tag) is selected.
+func ReadOCILayout(dir string, image string) (*Image, error) {
+	if _, err := os.Stat(filepath.Join(dir, ociLayoutFile)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotOCILayout
+		}
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, ociIndexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index ociIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, err
+	}
+
+	descriptor, err := selectOCIManifest(index.Manifests, image)
+	if err != nil {
+		return nil, err
+	}
+
+	if descriptor.MediaType != MediaTypeOCIManifest && descriptor.MediaType != MediaTypeDockerManifest {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	manifestContent, err := ioutil.ReadFile(blobPath(dir, descriptor.Digest))
+	if err != nil {
+		return nil, err
+	}
+
+	if digestOf(manifestContent) != descriptor.Digest {
+		return nil, ErrDigestMismatch
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestContent, &manifest); err != nil {
+		return nil, err
+	}
+
+	result := &Image{
+		Manifest:  manifestContent,
+		MediaType: descriptor.MediaType,
+		Config:    fileBlob(manifest.Config, blobPath(dir, manifest.Config.Digest)),
+	}
+
+	for _, layer := range manifest.Layers {
+		result.Layers = append(result.Layers, fileBlob(layer, blobPath(dir, layer.Digest)))
+	}
+
+	return result, nil
+}
+
+func selectOCIManifest(manifests []Descriptor, image string) (*Descriptor, error) {
+	if len(manifests) == 0 {
+		return nil, ErrNoImageInArchive
+	}
+
+	for i := range manifests {
+		if refName, ok := manifests[i].Annotations[ociRefNameAnnotation]; ok && matchesRefName(refName, image) {
+			return &manifests[i], nil
+		}
+	}
+
+	if len(manifests) == 1 {
+		return &manifests[0], nil
+	}
+
+	return nil, ErrAmbiguousImage
+}
+
+// matchesRefName reports whether an OCI ref name annotation, which may be either a full image name or only a tag,
+// refers to 'image'
+func matchesRefName(refName string, image string) bool {
+	return refName == image || strings.HasSuffix(image, ":"+refName)
+}
+
+func blobPath(dir string, digest string) string {
+	return filepath.Join(dir, "blobs", strings.Replace(digest, ":", string(filepath.Separator), 1))
+}
@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_ReadOCILayout_ReadsManifestAndBlobs(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	manifest := writeOCILayout(t, dir, map[string]string{"1.0": `{"schemaVersion":2}`})
+
+	image, err := ReadOCILayout(dir, "app:1.0")
+
+	require.NoError(t, err)
+	require.Equal(t, manifest, string(image.Manifest))
+	require.Equal(t, MediaTypeOCIManifest, image.MediaType)
+	require.Len(t, image.Layers, 1)
+}
+
+func TestArchive_ReadOCILayout_SelectsManifestByRefName(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeOCILayout(t, dir, map[string]string{
+		"1.0": `{"version":"1.0"}`,
+		"2.0": `{"version":"2.0"}`,
+	})
+
+	image, err := ReadOCILayout(dir, "app:2.0")
+
+	require.NoError(t, err)
+	require.Equal(t, digestOf([]byte(`{"version":"2.0"}`)), image.Config.Digest)
+}
+
+func TestArchive_ReadOCILayout_ReturnsErrNotOCILayout(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := ReadOCILayout(dir, "app:1.0")
+
+	require.Equal(t, ErrNotOCILayout, err)
+}
+
+func TestArchive_ReadOCILayout_ReturnsErrUnsupportedMediaTypeForIndex(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, ociLayoutFile), `{"imageLayoutVersion":"1.0.0"}`)
+	writeFile(t, filepath.Join(dir, ociIndexFile),
+		`{"schemaVersion":2,"manifests":[{"mediaType":"`+MediaTypeOCIIndex+`","digest":"sha256:abcd","size":2}]}`)
+
+	_, err := ReadOCILayout(dir, "app:1.0")
+
+	require.Equal(t, ErrUnsupportedMediaType, err)
+}
+
+func TestArchive_MatchesRefName(t *testing.T) {
+	require.True(t, matchesRefName("1.0", "app:1.0"))
+	require.True(t, matchesRefName("app:1.0", "app:1.0"))
+	require.False(t, matchesRefName("1.0", "app:2.0"))
+}
+
+// writeOCILayout writes an OCI layout with one image per ref name, each using the given content as its config and
+// sharing a single layer. The manifest of the last written image is returned.
+func writeOCILayout(t *testing.T, dir string, configs map[string]string) string {
+	writeFile(t, filepath.Join(dir, ociLayoutFile), `{"imageLayoutVersion":"1.0.0"}`)
+
+	layer := writeBlob(t, dir, "layer")
+	index := `{"schemaVersion":2,"manifests":[`
+	var manifest string
+	for refName, config := range configs {
+		configDigest := writeBlob(t, dir, config)
+		manifest = `{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"` +
+			configDigest + `","size":1},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"` +
+			layer + `","size":5}]}`
+		manifestDigest := writeBlob(t, dir, manifest)
+		if index[len(index)-1] != '[' {
+			index += ","
+		}
+		index += `{"mediaType":"` + MediaTypeOCIManifest + `","digest":"` + manifestDigest +
+			`","size":1,"annotations":{"org.opencontainers.image.ref.name":"` + refName + `"}}`
+	}
+	writeFile(t, filepath.Join(dir, ociIndexFile), index+"]}")
+
+	return manifest
+}
+
+func writeBlob(t *testing.T, dir string, content string) string {
+	digest := digestOf([]byte(content))
+	writeFile(t, blobPath(dir, digest), content)
+	return digest
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
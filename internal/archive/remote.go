@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+)
+
+// stallTimeout is how long a layer download may go without receiving any data before it is abandoned. Downloads have
+// no overall timeout, as layers can be arbitrarily large.
+var stallTimeout = time.Minute
+
+// layerClient downloads layers from the pre-signed URLs returned by ECR
+var layerClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// Fetch retrieves the manifest of an image from ECR. The blobs of the returned image are downloaded from ECR when
+// they are opened, and their content is verified against their digest as it is read.
+func Fetch(ecrClient ecr.Client, repository string, reference string) (*Image, error) {
+	imageManifest, err := ecrClient.GetImageManifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if imageManifest.MediaType != "" && imageManifest.MediaType != MediaTypeDockerManifest &&
+		imageManifest.MediaType != MediaTypeOCIManifest {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(imageManifest.Manifest), &manifest); err != nil {
+		return nil, err
+	}
+
+	mediaType := imageManifest.MediaType
+	if mediaType == "" {
+		mediaType = manifest.MediaType
+	}
+
+	image := &Image{
+		Manifest:  []byte(imageManifest.Manifest),
+		MediaType: mediaType,
+		Config:    remoteBlob(ecrClient, repository, manifest.Config),
+	}
+
+	for _, layer := range manifest.Layers {
+		image.Layers = append(image.Layers, remoteBlob(ecrClient, repository, layer))
+	}
+
+	return image, nil
+}
+
+func remoteBlob(ecrClient ecr.Client, repository string, descriptor Descriptor) Blob {
+	return Blob{
+		Descriptor: descriptor,
+		open: func() (io.ReadCloser, error) {
+			url, err := ecrClient.GetLayerURL(repository, descriptor.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			request, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			timer := time.AfterFunc(stallTimeout, cancel)
+
+			response, err := layerClient.Do(request.WithContext(ctx))
+			timer.Stop()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+
+			if response.StatusCode != http.StatusOK {
+				response.Body.Close()
+				cancel()
+				return nil, fmt.Errorf("downloading layer %s: unexpected status %s", descriptor.Digest, response.Status)
+			}
+
+			return &verifyingReader{
+				ReadCloser: &stallReader{ReadCloser: response.Body, ctx: ctx, cancel: cancel, timer: timer},
+				hash:       sha256.New(),
+				digest:     descriptor.Digest,
+			}, nil
+		},
+	}
+}
+
+// stallReader cancels a download when a read does not return within stallTimeout
+type stallReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *time.Timer
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.timer.Reset(stallTimeout)
+	n, err := r.ReadCloser.Read(p)
+	r.timer.Stop()
+
+	if err != nil && err != io.EOF && r.ctx.Err() != nil {
+		return n, ErrDownloadStalled
+	}
+
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// verifyingReader returns ErrDigestMismatch instead of io.EOF when the content read does not match the digest
+type verifyingReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+
+	if err == io.EOF && fmt.Sprintf("sha256:%x", r.hash.Sum(nil)) != r.digest {
+		return n, ErrDigestMismatch
+	}
+
+	return n, err
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_Fetch_DownloadsAndVerifiesBlobs(t *testing.T) {
+	server := newBlobServer(map[string]string{"/config": "config", "/layer": "tampered"})
+	defer server.Close()
+	manifest := `{"schemaVersion":2,"config":{"digest":"` + digestOf([]byte("config")) + `","size":6},` +
+		`"layers":[{"digest":"` + digestOf([]byte("layer")) + `","size":5}]}`
+	m := &mockECRClient{}
+	m.On("GetImageManifest", "repo", "1.2.3").Return(&ecr.ImageManifest{
+		Digest:    "sha256:digest",
+		MediaType: MediaTypeDockerManifest,
+		Manifest:  manifest,
+	}, nil)
+	m.On("GetLayerURL", "repo", digestOf([]byte("config"))).Return(server.URL+"/config", nil)
+	m.On("GetLayerURL", "repo", digestOf([]byte("layer"))).Return(server.URL+"/layer", nil)
+
+	image, err := Fetch(m, "repo", "1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, manifest, string(image.Manifest))
+
+	config, err := image.Config.Open()
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(config)
+	require.NoError(t, err)
+	require.Equal(t, "config", string(content))
+
+	layer, err := image.Layers[0].Open()
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(layer)
+	require.Equal(t, ErrDigestMismatch, err)
+}
+
+func TestArchive_Fetch_AbandonsStalledDownloads(t *testing.T) {
+	defer func(timeout time.Duration) { stallTimeout = timeout }(stallTimeout)
+	stallTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		_, _ = w.Write([]byte("la"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	m := &mockECRClient{}
+	m.On("GetImageManifest", "repo", "1.2.3").Return(&ecr.ImageManifest{
+		MediaType: MediaTypeDockerManifest,
+		Manifest:  `{"schemaVersion":2,"config":{"digest":"sha256:config"},"layers":[]}`,
+	}, nil)
+	m.On("GetLayerURL", "repo", "sha256:config").Return(server.URL, nil)
+
+	image, err := Fetch(m, "repo", "1.2.3")
+	require.NoError(t, err)
+	config, err := image.Config.Open()
+	require.NoError(t, err)
+	defer config.Close()
+	_, err = ioutil.ReadAll(config)
+
+	require.Equal(t, ErrDownloadStalled, err)
+}
+
+func TestArchive_Fetch_ReturnsErrUnsupportedMediaType(t *testing.T) {
+	m := &mockECRClient{}
+	m.On("GetImageManifest", mock.Anything, mock.Anything).Return(&ecr.ImageManifest{
+		MediaType: MediaTypeDockerList,
+		Manifest:  "{}",
+	}, nil)
+
+	_, err := Fetch(m, "repo", "1.2.3")
+
+	require.Equal(t, ErrUnsupportedMediaType, err)
+}
+
+func newBlobServer(blobs map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := blobs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+}
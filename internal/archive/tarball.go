@@ -0,0 +1,286 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const tarballManifestName = "manifest.json"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// tarballManifest is an entry of the manifest.json file written by 'docker save'
+type tarballManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// ReadTarball reads an image from a tarball created by 'docker save' (or a compatible tool such as Kaniko or
+// buildah). When the tarball contains more than one image, the one tagged as 'image' is selected. Uncompressed layers
+// are gzip-compressed into a temporary directory, which is removed when the returned image is closed.
+func ReadTarball(tarball string, image string) (*Image, error) {
+	entry, links, err := readTarballManifest(tarball, image)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := ioutil.TempDir("", "trebuchet-")
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := readTarballBlobs(tarball, entry, links, tempDir)
+	if err != nil {
+		_ = os.RemoveAll(tempDir)
+		return nil, err
+	}
+
+	result.cleanup = func() error {
+		return os.RemoveAll(tempDir)
+	}
+
+	return result, nil
+}
+
+// readTarballManifest returns the manifest entry of the image and the links in the tarball
+func readTarballManifest(tarball string, image string) (*tarballManifest, map[string]string, error) {
+	var entries []tarballManifest
+	found := false
+
+	links, err := walkTarball(tarball, func(name string, reader io.Reader) error {
+		if name != tarballManifestName {
+			return nil
+		}
+
+		found = true
+		return json.NewDecoder(reader).Decode(&entries)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !found {
+		return nil, nil, ErrMissingArchiveManifest
+	}
+
+	entry, err := selectTarballManifest(entries, image)
+	return entry, links, err
+}
+
+func selectTarballManifest(entries []tarballManifest, image string) (*tarballManifest, error) {
+	if len(entries) == 0 {
+		return nil, ErrNoImageInArchive
+	}
+
+	for i := range entries {
+		for _, tag := range entries[i].RepoTags {
+			if tag == image {
+				return &entries[i], nil
+			}
+		}
+	}
+
+	if len(entries) == 1 {
+		return &entries[0], nil
+	}
+
+	return nil, ErrAmbiguousImage
+}
+
+// readTarballBlobs reads the config and layers of the image. Files may be links to other files, as 'docker save'
+// stores layers shared by several images once and links to them.
+func readTarballBlobs(tarball string, entry *tarballManifest, links map[string]string, tempDir string) (*Image, error) {
+	var config []byte
+	layers := map[string]Blob{}
+	configName := resolveLink(links, entry.Config)
+
+	wanted := map[string]bool{}
+	for _, layer := range entry.Layers {
+		wanted[resolveLink(links, layer)] = true
+	}
+
+	_, err := walkTarball(tarball, func(name string, reader io.Reader) error {
+		if name == configName {
+			var err error
+			config, err = ioutil.ReadAll(reader)
+			return err
+		}
+
+		if !wanted[name] {
+			return nil
+		}
+
+		if _, ok := layers[name]; ok {
+			return nil
+		}
+
+		blob, err := compressLayer(reader, filepath.Join(tempDir, fmt.Sprintf("layer-%d", len(layers))))
+		if err != nil {
+			return err
+		}
+
+		layers[name] = blob
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("config %s not found in archive", entry.Config)
+	}
+
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := ioutil.WriteFile(configPath, config, 0600); err != nil {
+		return nil, err
+	}
+
+	image := &Image{
+		MediaType: MediaTypeDockerManifest,
+		Config: fileBlob(Descriptor{
+			MediaType: MediaTypeDockerConfig,
+			Size:      int64(len(config)),
+			Digest:    digestOf(config),
+		}, configPath),
+	}
+
+	for _, name := range entry.Layers {
+		blob, ok := layers[resolveLink(links, name)]
+		if !ok {
+			return nil, fmt.Errorf("layer %s not found in archive", name)
+		}
+		image.Layers = append(image.Layers, blob)
+	}
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifest,
+		Config:        image.Config.Descriptor,
+	}
+	for _, layer := range image.Layers {
+		manifest.Layers = append(manifest.Layers, layer.Descriptor)
+	}
+
+	image.Manifest, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// compressLayer writes the layer to 'target', gzip-compressing it unless it is already compressed, and returns a blob
+// describing the written file
+func compressLayer(layer io.Reader, target string) (Blob, error) {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	output := io.MultiWriter(file, hash, counter)
+
+	buffered := bufio.NewReader(layer)
+	magic, _ := buffered.Peek(len(gzipMagic))
+
+	if bytes.Equal(magic, gzipMagic) {
+		if _, err := io.Copy(output, buffered); err != nil {
+			return Blob{}, err
+		}
+	} else {
+		compressor := gzip.NewWriter(output)
+		if _, err := io.Copy(compressor, buffered); err != nil {
+			return Blob{}, err
+		}
+		if err := compressor.Close(); err != nil {
+			return Blob{}, err
+		}
+	}
+
+	return fileBlob(Descriptor{
+		MediaType: MediaTypeDockerLayer,
+		Size:      counter.count,
+		Digest:    fmt.Sprintf("sha256:%x", hash.Sum(nil)),
+	}, target), nil
+}
+
+// maxLinkDepth is the number of links followed to resolve a file, which stops link cycles
+const maxLinkDepth = 16
+
+// walkTarball calls 'visit' with the cleaned name and content of every regular file in the tarball, and returns the
+// cleaned names of the files that symbolic and hard links in the tarball refer to
+func walkTarball(tarball string, visit func(name string, reader io.Reader) error) (map[string]string, error) {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	links := map[string]string{}
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return links, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(header.Name)
+		switch header.Typeflag {
+		case tar.TypeReg:
+			if err := visit(name, reader); err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			// Symbolic links are relative to their directory, and absolute ones to the root of the tarball
+			target := header.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(name), target)
+			}
+			links[name] = path.Clean(strings.TrimPrefix(target, "/"))
+		case tar.TypeLink:
+			links[name] = path.Clean(strings.TrimPrefix(header.Linkname, "/"))
+		}
+	}
+}
+
+// resolveLink returns the cleaned name of the file that 'name' refers to, following links
+func resolveLink(links map[string]string, name string) string {
+	name = path.Clean(name)
+	for i := 0; i < maxLinkDepth; i++ {
+		target, ok := links[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+
+	return name
+}
+
+type countingWriter struct {
+	count int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.count += int64(len(p))
+	return len(p), nil
+}
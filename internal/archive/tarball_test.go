@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_ReadTarball_BuildsCompressedManifest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tarball := writeTarball(t, dir, map[string]string{
+		"manifest.json":       `[{"Config":"config.json","RepoTags":["app:1.0"],"Layers":["layer1/layer.tar"]}]`,
+		"config.json":         `{"architecture":"amd64"}`,
+		"layer1/layer.tar":    "layer content",
+		"layer1/json":         "{}",
+		"unrelated/layer.tar": "unused",
+	})
+
+	image, err := ReadTarball(tarball, "app:1.0")
+	require.NoError(t, err)
+	defer image.Close()
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(image.Manifest, &manifest))
+	require.Equal(t, MediaTypeDockerManifest, image.MediaType)
+	require.Equal(t, digestOf([]byte(`{"architecture":"amd64"}`)), manifest.Config.Digest)
+	require.Len(t, manifest.Layers, 1)
+	require.Equal(t, MediaTypeDockerLayer, manifest.Layers[0].MediaType)
+
+	reader, err := image.Layers[0].Open()
+	require.NoError(t, err)
+	defer reader.Close()
+	compressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, digestOf(compressed), manifest.Layers[0].Digest)
+	require.Equal(t, int64(len(compressed)), manifest.Layers[0].Size)
+}
+
+func TestArchive_ReadTarball_ResolvesLinkedLayers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tarball := writeTarball(t, dir, map[string]string{
+		"manifest.json": `[{"Config":"config.json","RepoTags":["app:1.0"],"Layers":["a/layer.tar","b/layer.tar","c/layer.tar"]}]`,
+		"config.json":   `{"architecture":"amd64"}`,
+		"a/layer.tar":   "layer content",
+	})
+	appendLinks(t, tarball, []tar.Header{
+		{Name: "b/layer.tar", Linkname: "../a/layer.tar", Typeflag: tar.TypeSymlink},
+		{Name: "c/layer.tar", Linkname: "a/layer.tar", Typeflag: tar.TypeLink},
+	})
+
+	image, err := ReadTarball(tarball, "app:1.0")
+	require.NoError(t, err)
+	defer image.Close()
+
+	require.Len(t, image.Layers, 3)
+	require.Equal(t, image.Layers[0].Digest, image.Layers[1].Digest)
+	require.Equal(t, image.Layers[0].Digest, image.Layers[2].Digest)
+}
+
+func TestArchive_ReadTarball_SelectsImageByTag(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tarball := writeTarball(t, dir, map[string]string{
+		"manifest.json": `[{"Config":"a.json","RepoTags":["app:1.0"],"Layers":[]},{"Config":"b.json","RepoTags":["app:2.0"],"Layers":[]}]`,
+		"a.json":        `{"a":true}`,
+		"b.json":        `{"b":true}`,
+	})
+
+	image, err := ReadTarball(tarball, "app:2.0")
+	require.NoError(t, err)
+	defer image.Close()
+
+	require.Equal(t, digestOf([]byte(`{"b":true}`)), image.Config.Digest)
+}
+
+func TestArchive_ReadTarball_ReturnsErrAmbiguousImage(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tarball := writeTarball(t, dir, map[string]string{
+		"manifest.json": `[{"Config":"a.json","RepoTags":["app:1.0"]},{"Config":"b.json","RepoTags":["app:2.0"]}]`,
+	})
+
+	_, err := ReadTarball(tarball, "app:3.0")
+
+	require.Equal(t, ErrAmbiguousImage, err)
+}
+
+func TestArchive_ReadTarball_ReturnsErrMissingArchiveManifest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tarball := writeTarball(t, dir, map[string]string{"config.json": "{}"})
+
+	_, err := ReadTarball(tarball, "app:1.0")
+
+	require.Equal(t, ErrMissingArchiveManifest, err)
+}
+
+func TestArchive_CompressLayer_KeepsCompressedLayers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source.gz")
+	file, err := os.Create(source)
+	require.NoError(t, err)
+	compressor := gzip.NewWriter(file)
+	_, _ = compressor.Write([]byte("already compressed"))
+	require.NoError(t, compressor.Close())
+	require.NoError(t, file.Close())
+	expected, err := ioutil.ReadFile(source)
+	require.NoError(t, err)
+
+	input, err := os.Open(source)
+	require.NoError(t, err)
+	defer input.Close()
+	blob, err := compressLayer(input, filepath.Join(dir, "target"))
+
+	require.NoError(t, err)
+	require.Equal(t, digestOf(expected), blob.Digest)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "trebuchet-test-")
+	require.NoError(t, err)
+	return dir
+}
+
+func writeTarball(t *testing.T, dir string, files map[string]string) string {
+	path := filepath.Join(dir, "image.tar")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	writer := tar.NewWriter(file)
+	for name, content := range files {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := writer.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	return path
+}
+
+// appendLinks rewrites the tarball with the link entries added after its files
+func appendLinks(t *testing.T, tarball string, links []tar.Header) {
+	content, err := ioutil.ReadFile(tarball)
+	require.NoError(t, err)
+
+	file, err := os.Create(tarball)
+	require.NoError(t, err)
+	defer file.Close()
+
+	reader := tar.NewReader(bytes.NewReader(content))
+	writer := tar.NewWriter(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.NoError(t, writer.WriteHeader(header))
+		_, err = io.Copy(writer, reader)
+		require.NoError(t, err)
+	}
+	for i := range links {
+		links[i].Mode = 0777
+		require.NoError(t, writer.WriteHeader(&links[i]))
+	}
+	require.NoError(t, writer.Close())
+}
@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const ociLayoutContent = `{"imageLayoutVersion":"1.0.0"}`
+
+// WriteTarball writes the image to a tarball in the format produced by 'docker save', so that it can be loaded with
+// 'docker load' and read by tools that accept such archives. The image is tagged as 'repoTag' in the archive.
+func WriteTarball(image *Image, tarball string, repoTag string) (err error) {
+	file, err := os.Create(tarball)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(tarball)
+		}
+	}()
+
+	writer := tar.NewWriter(file)
+
+	entry := tarballManifest{
+		Config: digestHex(image.Config.Digest) + ".json",
+	}
+	if repoTag != "" {
+		entry.RepoTags = []string{repoTag}
+	}
+
+	if err := writeTarEntry(writer, entry.Config, image.Config); err != nil {
+		return err
+	}
+
+	written := map[string]bool{}
+	for _, layer := range image.Layers {
+		name := digestHex(layer.Digest) + "/layer.tar"
+		entry.Layers = append(entry.Layers, name)
+
+		if written[name] {
+			continue
+		}
+		written[name] = true
+
+		if err := writeTarEntry(writer, name, layer); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.Marshal([]tarballManifest{entry})
+	if err != nil {
+		return err
+	}
+
+	if err := writer.WriteHeader(&tar.Header{
+		Name:     tarballManifestName,
+		Mode:     0644,
+		Size:     int64(len(manifest)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(manifest); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// WriteOCILayout writes the image to an OCI image layout directory, creating it if it does not exist. The image is
+// added to the index of an existing layout, replacing any image with the same 'refName'.
+func WriteOCILayout(image *Image, dir string, refName string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ociLayoutFile), []byte(ociLayoutContent), 0644); err != nil {
+		return err
+	}
+
+	for _, blob := range image.Blobs() {
+		if err := writeOCIBlob(dir, blob); err != nil {
+			return err
+		}
+	}
+
+	descriptor := Descriptor{
+		MediaType: image.MediaType,
+		Size:      int64(len(image.Manifest)),
+		Digest:    image.Digest(),
+	}
+	if refName != "" {
+		descriptor.Annotations = map[string]string{ociRefNameAnnotation: refName}
+	}
+
+	if err := ioutil.WriteFile(blobPath(dir, descriptor.Digest), image.Manifest, 0644); err != nil {
+		return err
+	}
+
+	index, err := readOCIIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	manifests := []Descriptor{}
+	for _, existing := range index.Manifests {
+		if refName == "" || existing.Annotations[ociRefNameAnnotation] != refName {
+			manifests = append(manifests, existing)
+		}
+	}
+	index.Manifests = append(manifests, descriptor)
+
+	content, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, ociIndexFile), content, 0644)
+}
+
+func readOCIIndex(dir string) (*ociIndex, error) {
+	index := &ociIndex{SchemaVersion: 2}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, ociIndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// writeOCIBlob downloads the blob into the layout, unless the layout already contains it
+func writeOCIBlob(dir string, blob Blob) error {
+	target := blobPath(dir, blob.Digest)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	reader, err := blob.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	temp, err := ioutil.TempFile(filepath.Dir(target), "download-")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(temp, reader)
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), target)
+}
+
+func writeTarEntry(writer *tar.Writer, name string, blob Blob) error {
+	reader, err := blob.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := writer.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     blob.Size,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+func digestHex(digest string) string {
+	return digest[strings.Index(digest, ":")+1:]
+}
@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_WriteTarball_CanBeReadBack(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	image := testImage()
+	tarball := filepath.Join(dir, "out.tar")
+
+	require.NoError(t, WriteTarball(image, tarball, "app:1.0"))
+
+	result, err := ReadTarball(tarball, "app:1.0")
+	require.NoError(t, err)
+	defer result.Close()
+	require.Equal(t, image.Config.Digest, result.Config.Digest)
+	require.Len(t, result.Layers, 1)
+}
+
+func TestArchive_WriteTarball_RemovesArchiveOnError(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	image := testImage()
+	image.Layers[0].Size = 1
+	tarball := filepath.Join(dir, "out.tar")
+
+	require.Error(t, WriteTarball(image, tarball, "app:1.0"))
+
+	_, err := os.Stat(tarball)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestArchive_WriteOCILayout_CanBeReadBack(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	image := testImage()
+
+	require.NoError(t, WriteOCILayout(image, dir, "1.0"))
+	require.NoError(t, WriteOCILayout(image, dir, "1.0"))
+
+	result, err := ReadOCILayout(dir, "app:1.0")
+	require.NoError(t, err)
+	require.Equal(t, image.Manifest, result.Manifest)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, ociIndexFile))
+	require.NoError(t, err)
+	var index ociIndex
+	require.NoError(t, json.Unmarshal(content, &index))
+	require.Len(t, index.Manifests, 1)
+}
+
+func testImage() *Image {
+	config := stringBlob(digestOf([]byte("config")), "config")
+	layer := stringBlob(digestOf([]byte("layer")), "layer")
+	manifest, _ := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        config.Descriptor,
+		Layers:        []Descriptor{layer.Descriptor},
+	})
+
+	return &Image{
+		Manifest:  manifest,
+		MediaType: MediaTypeOCIManifest,
+		Config:    config,
+		Layers:    []Blob{layer},
+	}
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expiryWindow is how long before their expiry cached values are no longer used, so that a value is never handed out
+// just before it expires
+const expiryWindow = 5 * time.Minute
+
+// Cache stores values such as authorization tokens and temporary credentials in files until they expire. The files
+// are only readable by the current user, and access to each value is serialized with a file lock so that parallel
+// invocations of trebuchet request a new value only once.
+type Cache struct {
+	dir string
+	log *log.Entry
+	now func() time.Time
+}
+
+type entry struct {
+	ExpiresAt time.Time       `json:"expiresAt"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// DefaultDir returns the directory trebuchet caches values in, within the cache directory of the current user
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "trebuchet"), nil
+}
+
+// New creates a cache that stores its files in 'dir'
+func New(dir string) *Cache {
+	return &Cache{
+		dir: dir,
+		log: log.WithField("component", "cache"),
+		now: time.Now,
+	}
+}
+
+// GetOrCreate reads the value stored under 'key' into 'value'. When there is no value, or it is about to expire,
+// 'create' is called to fill in 'value' and return its expiry, and the new value is stored. Failures to read or write
+// the cache are logged and otherwise ignored, so the cache never causes a command to fail.
+func (c *Cache) GetOrCreate(key []string, value interface{}, create func() (time.Time, error)) error {
+	return c.GetOrCreateWithin(key, value, expiryWindow, create)
+}
+
+// GetOrCreateWithin is GetOrCreate for values that are recreated once they expire within 'window', such as credentials
+// that are refreshed that long before they expire. Windows shorter than five minutes are extended to five minutes.
+func (c *Cache) GetOrCreateWithin(key []string, value interface{}, window time.Duration, create func() (time.Time, error)) error {
+	if window < expiryWindow {
+		window = expiryWindow
+	}
+
+	path := filepath.Join(c.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(strings.Join(key, "\x00")))))
+	fields := log.Fields{"key": key[0], "file": path}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		c.log.WithError(err).WithFields(fields).Warn("Error creating cache directory")
+		_, err := create()
+		return err
+	}
+
+	unlock, err := LockFile(path + ".lock")
+	if err != nil {
+		c.log.WithError(err).WithFields(fields).Warn("Error locking cache entry")
+	} else {
+		defer unlock()
+	}
+
+	if c.read(path, value, window) {
+		c.log.WithFields(fields).Debug("Using cached value")
+		return nil
+	}
+
+	expiresAt, err := create()
+	if err != nil {
+		return err
+	}
+
+	if err := c.write(path, value, expiresAt); err != nil {
+		c.log.WithError(err).WithFields(fields).Warn("Error writing cache entry")
+	}
+
+	c.sweep(path)
+	return nil
+}
+
+// read reads the value in the file at 'path', returning false if there is none or it expires within 'window'. Expired
+// entries are removed.
+func (c *Cache) read(path string, value interface{}, window time.Duration) bool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var cached entry
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return false
+	}
+
+	if c.now().After(cached.ExpiresAt) {
+		_ = os.Remove(path)
+		return false
+	}
+
+	if c.now().Add(window).After(cached.ExpiresAt) {
+		return false
+	}
+
+	return json.Unmarshal(cached.Value, value) == nil
+}
+
+// write replaces the file at 'path' with the value
+func (c *Cache) write(path string, value interface{}, expiresAt time.Time) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	content, err = json.Marshal(entry{ExpiresAt: expiresAt, Value: content})
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(c.dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// sweep removes expired entries other than the one at 'path', and lock files left behind by removed entries, so that
+// keys and credentials do not stay on disk once they expire. Entries locked by other callers are skipped.
+func (c *Cache) sweep(path string) {
+	entries, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	locks, _ := filepath.Glob(filepath.Join(c.dir, "*.json.lock"))
+	for _, lock := range locks {
+		entries = append(entries, strings.TrimSuffix(lock, ".lock"))
+	}
+
+	swept := map[string]bool{path: true}
+	for _, entry := range entries {
+		if swept[entry] {
+			continue
+		}
+		swept[entry] = true
+
+		unlock, err := tryLockFile(entry + ".lock")
+		if err != nil {
+			continue
+		}
+
+		expired := c.expired(entry)
+		if expired {
+			_ = os.Remove(entry)
+		}
+		unlock()
+
+		if expired {
+			_ = os.Remove(entry + ".lock")
+			c.log.WithField("file", entry).Debug("Removed expired cache entry")
+		}
+	}
+}
+
+// expired returns true if the entry at 'path' has expired or no longer exists
+func (c *Cache) expired(path string) bool {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return true
+	}
+
+	var cached entry
+	if err != nil || json.Unmarshal(content, &cached) != nil {
+		return false
+	}
+
+	return c.now().After(cached.ExpiresAt)
+}
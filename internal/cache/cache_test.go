@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type token struct {
+	Value string
+}
+
+func TestCache_GetOrCreate_StoresCreatedValue(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	calls := 0
+	create := func(result *token) func() (time.Time, error) {
+		return func() (time.Time, error) {
+			calls++
+			result.Value = "secret"
+			return time.Now().Add(time.Hour), nil
+		}
+	}
+
+	var first, second token
+	require.NoError(t, c.GetOrCreate([]string{"ecr", "us-east-1"}, &first, create(&first)))
+	require.NoError(t, c.GetOrCreate([]string{"ecr", "us-east-1"}, &second, create(&second)))
+
+	require.Equal(t, 1, calls)
+	require.Equal(t, "secret", first.Value)
+	require.Equal(t, "secret", second.Value)
+}
+
+func TestCache_GetOrCreate_SeparatesKeys(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	calls := 0
+
+	for _, region := range []string{"us-east-1", "us-west-2"} {
+		var result token
+		require.NoError(t, c.GetOrCreate([]string{"ecr", region}, &result, func() (time.Time, error) {
+			calls++
+			return time.Now().Add(time.Hour), nil
+		}))
+	}
+
+	require.Equal(t, 2, calls)
+}
+
+func TestCache_GetOrCreate_RecreatesValueAboutToExpire(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	calls := 0
+	create := func() (time.Time, error) {
+		calls++
+		return time.Now().Add(10 * time.Minute), nil
+	}
+
+	var result token
+	require.NoError(t, c.GetOrCreate([]string{"ecr"}, &result, create))
+	c.now = func() time.Time { return time.Now().Add(6 * time.Minute) }
+	require.NoError(t, c.GetOrCreate([]string{"ecr"}, &result, create))
+
+	require.Equal(t, 2, calls)
+}
+
+func TestCache_GetOrCreateWithin_RecreatesValueExpiringWithinWindow(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	calls := 0
+	create := func() (time.Time, error) {
+		calls++
+		return time.Now().Add(30 * time.Minute), nil
+	}
+
+	var result token
+	require.NoError(t, c.GetOrCreateWithin([]string{"sts"}, &result, 15*time.Minute, create))
+	c.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	require.NoError(t, c.GetOrCreateWithin([]string{"sts"}, &result, 15*time.Minute, create))
+	require.Equal(t, 1, calls)
+
+	c.now = func() time.Time { return time.Now().Add(20 * time.Minute) }
+	require.NoError(t, c.GetOrCreateWithin([]string{"sts"}, &result, 15*time.Minute, create))
+	require.Equal(t, 2, calls)
+}
+
+func TestCache_GetOrCreateWithin_KeepsMinimumWindow(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	calls := 0
+	create := func() (time.Time, error) {
+		calls++
+		return time.Now().Add(10 * time.Minute), nil
+	}
+
+	var result token
+	require.NoError(t, c.GetOrCreateWithin([]string{"sts"}, &result, time.Minute, create))
+	c.now = func() time.Time { return time.Now().Add(6 * time.Minute) }
+	require.NoError(t, c.GetOrCreateWithin([]string{"sts"}, &result, time.Minute, create))
+
+	require.Equal(t, 2, calls)
+}
+
+func TestCache_GetOrCreate_RemovesExpiredEntry(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	var result token
+	require.NoError(t, c.GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+		return time.Now().Add(10 * time.Minute), nil
+	}))
+	require.Len(t, cacheFiles(dir), 1)
+
+	c.now = func() time.Time { return time.Now().Add(20 * time.Minute) }
+	err := c.GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+		return time.Time{}, errors.New("expired token")
+	})
+
+	require.Error(t, err)
+	require.Empty(t, cacheFiles(dir))
+}
+
+func TestCache_GetOrCreate_SweepsExpiredEntriesAndLocks(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	var result token
+	require.NoError(t, c.GetOrCreate([]string{"ecr", "AKIAEXAMPLE"}, &result, func() (time.Time, error) {
+		return time.Now().Add(10 * time.Minute), nil
+	}))
+	orphan := filepath.Join(dir, "trebuchet", "orphan.json.lock")
+	require.NoError(t, ioutil.WriteFile(orphan, nil, 0600))
+
+	c.now = func() time.Time { return time.Now().Add(20 * time.Minute) }
+	require.NoError(t, c.GetOrCreate([]string{"sts"}, &result, func() (time.Time, error) {
+		return time.Now().Add(time.Hour), nil
+	}))
+
+	files := cacheFiles(dir)
+	require.Len(t, files, 1)
+	locks, _ := filepath.Glob(filepath.Join(dir, "trebuchet", "*.lock"))
+	require.Equal(t, []string{files[0] + ".lock"}, locks)
+}
+
+func TestCache_GetOrCreate_IgnoresCorruptEntries(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	var result token
+	require.NoError(t, c.GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+		return time.Now().Add(time.Hour), nil
+	}))
+	files := cacheFiles(dir)
+	require.Len(t, files, 1)
+	require.NoError(t, ioutil.WriteFile(files[0], []byte("{"), 0600))
+
+	created := false
+	require.NoError(t, c.GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+		created = true
+		return time.Now().Add(time.Hour), nil
+	}))
+
+	require.True(t, created)
+}
+
+func TestCache_GetOrCreate_ReturnsCreateError(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	var result token
+
+	err := c.GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+		return time.Time{}, errors.New("error")
+	})
+
+	require.EqualError(t, err, "error")
+	files := cacheFiles(dir)
+	require.Empty(t, files)
+}
+
+func TestCache_GetOrCreate_FilesAreOnlyReadableByOwner(t *testing.T) {
+	c, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	var result token
+	require.NoError(t, c.GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+		return time.Now().Add(time.Hour), nil
+	}))
+
+	files := cacheFiles(dir)
+	require.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestCache_GetOrCreate_CreatesOnceForParallelCallers(t *testing.T) {
+	_, dir := newTestCache(t)
+	defer os.RemoveAll(dir)
+	var mutex sync.Mutex
+	calls := 0
+
+	var group sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			var result token
+			// Each caller has its own cache, like separate invocations of trebuchet
+			require.NoError(t, New(dir).GetOrCreate([]string{"ecr"}, &result, func() (time.Time, error) {
+				mutex.Lock()
+				calls++
+				mutex.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				return time.Now().Add(time.Hour), nil
+			}))
+		}()
+	}
+	group.Wait()
+
+	require.Equal(t, 1, calls)
+}
+
+func newTestCache(t *testing.T) (*Cache, string) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	return New(filepath.Join(dir, "trebuchet")), dir
+}
+
+func cacheFiles(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "trebuchet", "*.json"))
+	return files
+}
@@ -0,0 +1,36 @@
+// +build !windows
+
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// LockFile takes an exclusive lock on the file at 'path', creating it if needed, and waits until the lock is acquired
+func LockFile(path string) (func(), error) {
+	return lockFile(path, unix.LOCK_EX)
+}
+
+// tryLockFile is LockFile without waiting, failing if the lock is held by someone else
+func tryLockFile(path string) (func(), error) {
+	return lockFile(path, unix.LOCK_EX|unix.LOCK_NB)
+}
+
+func lockFile(path string, how int) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,37 @@
+// +build windows
+
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// LockFile takes an exclusive lock on the file at 'path', creating it if needed, and waits until the lock is acquired
+func LockFile(path string) (func(), error) {
+	return lockFile(path, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+// tryLockFile is LockFile without waiting, failing if the lock is held by someone else
+func tryLockFile(path string) (func(), error) {
+	return lockFile(path, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY)
+}
+
+func lockFile(path string, flags uint32) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	handle := windows.Handle(file.Fd())
+	if err := windows.LockFileEx(handle, flags, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = windows.UnlockFileEx(handle, 0, 1, 0, &windows.Overlapped{})
+		file.Close()
+	}, nil
+}
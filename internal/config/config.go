@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// fileName is the name of the default configuration file in the home directory, without its extension
+const fileName = ".trebuchet"
+
+// Load reads the configuration file 'file' into 'v', providing defaults for the flags bound to it. When 'file' is
+// empty, $HOME/.trebuchet.yaml is read if it exists, while an explicitly given file must exist.
+func Load(v *viper.Viper, file string) error {
+	home, err := os.UserHomeDir()
+	if err != nil && file == "" {
+		return nil
+	}
+
+	return load(v, file, home)
+}
+
+func load(v *viper.Viper, file string, home string) error {
+	if file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.AddConfigPath(home)
+		v.SetConfigName(fileName)
+		v.SetConfigType("yaml")
+	}
+
+	err := v.ReadInConfig()
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok && file == "" {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `region: eu-west-1
+registry-id: "112233445566"
+as:
+  - arn:aws:iam::112233445566:role/first
+  - arn:aws:iam::112233445566:role/second
+session-tag:
+  team: platform
+`
+
+func TestConfig_Load_ReadsGivenFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "trebuchet.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testConfig), 0600))
+	v := viper.New()
+
+	err := load(v, file, "")
+
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", v.GetString("region"))
+	require.Equal(t, "112233445566", v.GetString("registry-id"))
+	require.Equal(t, []string{"arn:aws:iam::112233445566:role/first", "arn:aws:iam::112233445566:role/second"},
+		v.GetStringSlice("as"))
+	require.Equal(t, map[string]string{"team": "platform"}, v.GetStringMapString("session-tag"))
+}
+
+func TestConfig_Load_ReadsFileInHomeDirectory(t *testing.T) {
+	home := tempDir(t)
+	defer os.RemoveAll(home)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, ".trebuchet.yaml"), []byte(testConfig), 0600))
+	v := viper.New()
+
+	err := load(v, "", home)
+
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", v.GetString("region"))
+}
+
+func TestConfig_Load_IgnoresMissingFileInHomeDirectory(t *testing.T) {
+	home := tempDir(t)
+	defer os.RemoveAll(home)
+	v := viper.New()
+
+	err := load(v, "", home)
+
+	require.NoError(t, err)
+	require.Empty(t, v.AllKeys())
+}
+
+func TestConfig_Load_RejectsMissingGivenFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	err := load(viper.New(), filepath.Join(dir, "missing.yaml"), "")
+
+	require.Error(t, err)
+}
+
+func TestConfig_Load_RejectsInvalidFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "trebuchet.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("region: [eu-west-1"), 0600))
+
+	err := load(viper.New(), file, "")
+
+	require.Error(t, err)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "trebuchet-config")
+	require.NoError(t, err)
+	return dir
+}
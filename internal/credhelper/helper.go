@@ -0,0 +1,78 @@
+package credhelper
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hylandsoftware/trebuchet/internal/dockerconfig"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCredentialsNotFound is the error the Docker CLI expects from a credential helper that has no credentials for a
+// registry, in which case it continues without credentials instead of failing
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+var ErrUnknownAction = errors.New("action must be one of get, store, erase or list")
+
+// Credentials are the credentials of a registry as exchanged with the Docker CLI
+type Credentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// ClientFactory creates an ECR client for the ECR registry with the given host name
+type ClientFactory func(registry string) (ecr.Client, error)
+
+// Serve performs a credential helper action of the Docker credential helper protocol, reading its input from 'in'
+// and writing its output to 'out'. Credentials are only provided for ECR registries, using the authorization token of
+// the client, which comes from the cache and is only requested again shortly before it expires. Storing and erasing
+// credentials is accepted but has no effect, as there is nothing to store, and no registries are listed for the same
+// reason.
+func Serve(action string, in io.Reader, out io.Writer, newClient ClientFactory) error {
+	switch action {
+	case "get":
+		return get(in, out, newClient)
+	case "store", "erase":
+		_, err := io.Copy(ioutil.Discard, in)
+		return err
+	case "list":
+		return json.NewEncoder(out).Encode(map[string]string{})
+	}
+
+	return ErrUnknownAction
+}
+
+func get(in io.Reader, out io.Writer, newClient ClientFactory) error {
+	input, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	serverURL := strings.TrimSpace(string(input))
+	registry := dockerconfig.HostName(serverURL)
+	if _, _, ok := ecr.ParseRegistryHost(registry); !ok {
+		log.WithField("registry", registry).Debug("Registry is not an ECR registry")
+		return ErrCredentialsNotFound
+	}
+
+	ecrClient, err := newClient(registry)
+	if err != nil {
+		return err
+	}
+
+	auth, err := ecrClient.GetAuthorizationToken()
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(out).Encode(Credentials{
+		ServerURL: serverURL,
+		Username:  auth.Username,
+		Secret:    auth.Password,
+	})
+}
@@ -0,0 +1,85 @@
+package credhelper
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const registry = "112233445566.dkr.ecr.us-east-1.amazonaws.com"
+
+// mockECRClient only implements GetAuthorizationToken, the one operation the credential helper uses
+type mockECRClient struct {
+	ecr.Client
+	mock.Mock
+}
+
+func (m *mockECRClient) GetAuthorizationToken() (*ecr.RegistryAuth, error) {
+	args := m.Called()
+	auth, _ := args.Get(0).(*ecr.RegistryAuth)
+	return auth, args.Error(1)
+}
+
+func TestCredHelper_Get_ReturnsAuthorizationToken(t *testing.T) {
+	m := &mockECRClient{}
+	m.On("GetAuthorizationToken").Return(&ecr.RegistryAuth{Username: "AWS", Password: "token"}, nil)
+	var out bytes.Buffer
+	var created string
+
+	err := Serve("get", strings.NewReader("https://"+registry+"\n"), &out, func(registry string) (ecr.Client, error) {
+		created = registry
+		return m, nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, registry, created)
+	require.JSONEq(t, `{"ServerURL":"https://`+registry+`","Username":"AWS","Secret":"token"}`, out.String())
+}
+
+func TestCredHelper_Get_ReturnsErrCredentialsNotFoundForOtherRegistries(t *testing.T) {
+	err := Serve("get", strings.NewReader("registry.example.com"), &bytes.Buffer{}, func(registry string) (ecr.Client, error) {
+		t.Fatal("client must not be created for other registries")
+		return nil, nil
+	})
+
+	require.Equal(t, ErrCredentialsNotFound, err)
+}
+
+func TestCredHelper_Get_ReturnsAuthorizationTokenError(t *testing.T) {
+	m := &mockECRClient{}
+	m.On("GetAuthorizationToken").Return(nil, errors.New("error"))
+
+	err := Serve("get", strings.NewReader(registry), &bytes.Buffer{}, func(registry string) (ecr.Client, error) {
+		return m, nil
+	})
+
+	require.EqualError(t, err, "error")
+}
+
+func TestCredHelper_Store_IsAccepted(t *testing.T) {
+	input := `{"ServerURL":"` + registry + `","Username":"AWS","Secret":"token"}`
+
+	err := Serve("store", strings.NewReader(input), &bytes.Buffer{}, nil)
+
+	require.NoError(t, err)
+}
+
+func TestCredHelper_List_ReturnsNoRegistries(t *testing.T) {
+	var out bytes.Buffer
+
+	err := Serve("list", strings.NewReader(""), &out, nil)
+
+	require.NoError(t, err)
+	require.JSONEq(t, `{}`, out.String())
+}
+
+func TestCredHelper_Serve_RejectsUnknownAction(t *testing.T) {
+	err := Serve("version", strings.NewReader(""), &bytes.Buffer{}, nil)
+
+	require.Equal(t, ErrUnknownAction, err)
+}
@@ -6,14 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/reference"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
@@ -22,27 +21,43 @@ var ErrImageNotFound = errors.New("image not found on Docker host")
 
 type Client interface {
 	ImageExists(image string) error
-	ImagePush(image string, auth ecr.RegistryAuth) error
+	ImageInspect(image string) (*ImageDetails, error)
+	ImagePush(image string, auth ecr.RegistryAuth) (string, error)
 	ImagePull(image string, auth ecr.RegistryAuth) error
 	ImageTag(source string, target string) error
 	ImageRemove(image string) error
 }
 
+// ImageDetails identifies an image on the Docker host by its ID (the digest of its config) and the digests of the
+// manifests it was pushed or pulled as, in the form REPOSITORY@DIGEST
+type ImageDetails struct {
+	ID          string
+	RepoDigests []string
+}
+
 type dockerClient struct {
 	*client.Client
-	log *logrus.Entry
+	log      *logrus.Entry
+	progress *progressDisplay
 }
 
-// NewClient creates a new Docker client and logger to interact with the Docker API
-func NewClient() (Client, error) {
+// NewClient creates a new Docker client and logger to interact with the Docker API. The progress of pushes and pulls
+// is written to stderr in the given progress mode.
+func NewClient(progress string) (Client, error) {
+	display, err := newProgressDisplay(progress)
+	if err != nil {
+		return nil, err
+	}
+
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return nil, err
 	}
 
 	return &dockerClient{
-		Client: cli,
-		log:    log.WithField("component", "docker"),
+		Client:   cli,
+		log:      log.WithField("component", "docker"),
+		progress: display,
 	}, nil
 }
 
@@ -66,11 +81,24 @@ func (c *dockerClient) ImageExists(image string) error {
 	return nil
 }
 
-// ImagePush pushes a Docker image from the Docker host to ECR
-func (c *dockerClient) ImagePush(image string, auth ecr.RegistryAuth) error {
+// ImageInspect returns the ID and repository digests of an image on the Docker host
+func (c *dockerClient) ImageInspect(image string) (*ImageDetails, error) {
+	inspect, _, err := c.Client.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageDetails{
+		ID:          inspect.ID,
+		RepoDigests: inspect.RepoDigests,
+	}, nil
+}
+
+// ImagePush pushes a Docker image from the Docker host to ECR and returns the digest of the pushed manifest
+func (c *dockerClient) ImagePush(image string, auth ecr.RegistryAuth) (string, error) {
 	token, err := encodeRegistryAuthentication(auth)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	c.log.WithField("image", image).Info("Pushing image")
@@ -78,12 +106,21 @@ func (c *dockerClient) ImagePush(image string, auth ecr.RegistryAuth) error {
 		RegistryAuth: token,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	defer output.Close()
 
-	return jsonmessage.DisplayJSONMessagesStream(output, os.Stdout, 0, false, nil)
+	digest, err := c.progress.push(output)
+	if err != nil {
+		return "", err
+	}
+
+	c.log.WithFields(log.Fields{
+		"image":  image,
+		"digest": digest,
+	}).Info("Pushed image")
+	return digest, nil
 }
 
 // ImagePull pulls a Docker image from ECR to the Docker host
@@ -103,7 +140,7 @@ func (c *dockerClient) ImagePull(image string, auth ecr.RegistryAuth) error {
 
 	defer output.Close()
 
-	return jsonmessage.DisplayJSONMessagesStream(output, os.Stdout, 0, false, nil)
+	return c.progress.display(output, nil)
 }
 
 // ImageTag tags a Docker image on the Docker host with a new image name provided as the 'target' argument
@@ -129,29 +166,53 @@ func (c *dockerClient) ImageRemove(image string) error {
 	return nil
 }
 
-// TagAndPush will tag the image using the 'repositoryURI' and tag in the 'image' on the Docker host, then push the image
-// to ECR. The tagged image is always cleaned up, even if pushing the image fails.
-func TagAndPush(dockerClient Client, image string, repositoryURI string, auth ecr.RegistryAuth) (err error) {
-	fullRepositoryURI := getFullECRImageReference(repositoryURI, image)
-
-	if err = dockerClient.ImageTag(image, fullRepositoryURI); err != nil {
-		return err
-	}
+// TagAndPush will tag the image on the Docker host for each of the 'tags' in the 'repositoryURI', then push every tag
+// to ECR and return the digest of the pushed manifest. When no tags are given, the tag in 'image' is used. The tagged
+// images are always cleaned up, even if tagging or pushing an image fails.
+func TagAndPush(dockerClient Client, image string, repositoryURI string, tags []string, auth ecr.RegistryAuth) (digest string, err error) {
+	var tagged []string
 
 	defer func() {
-		removeErr := dockerClient.ImageRemove(fullRepositoryURI)
+		var removeErrs []string
+		for _, target := range tagged {
+			if removeErr := dockerClient.ImageRemove(target); removeErr != nil {
+				removeErrs = append(removeErrs, removeErr.Error())
+			}
+		}
+
+		if len(removeErrs) == 0 {
+			return
+		}
+
+		// An error tagging or pushing the image is the cause of the failure, so it comes first
 		if err != nil {
-			err = fmt.Errorf("%s: %s", err, removeErr)
+			err = fmt.Errorf("%s: %s", err, strings.Join(removeErrs, "; "))
 		} else {
-			err = removeErr
+			err = errors.New(strings.Join(removeErrs, "; "))
 		}
 	}()
 
-	if err = dockerClient.ImagePush(fullRepositoryURI, auth); err != nil {
-		return err
+	targets := getTargetImageReferences(repositoryURI, image, tags)
+
+	for _, target := range targets {
+		// The image may already be named after its ECR repository, in which case it must not be removed afterwards
+		if image != "" && target == image {
+			continue
+		}
+
+		if err = dockerClient.ImageTag(image, target); err != nil {
+			return "", err
+		}
+		tagged = append(tagged, target)
 	}
 
-	return nil
+	for _, target := range targets {
+		if digest, err = dockerClient.ImagePush(target, auth); err != nil {
+			return "", err
+		}
+	}
+
+	return digest, nil
 }
 
 // Pull will pull the image from ECR to the Docker host using the 'repositoryURI' and the tag from the 'image'. The
@@ -165,7 +226,13 @@ func Pull(dockerClient Client, image string, repositoryURI string, stripTag bool
 	}
 
 	if stripTag {
-		if err = dockerClient.ImageTag(fullRepositoryURI, image); err != nil {
+		localImage, ok := getStrippedImageReference(image)
+		if !ok {
+			log.WithField("image", fullRepositoryURI).Info("Image pulled by digest keeps its ECR name")
+			return nil
+		}
+
+		if err = dockerClient.ImageTag(fullRepositoryURI, localImage); err != nil {
 			return err
 		}
 
@@ -182,17 +249,67 @@ func Pull(dockerClient Client, image string, repositoryURI string, stripTag bool
 	return nil
 }
 
-func getFullECRImageReference(repositoryURI string, image string) string {
-	tag := ""
-	if strings.Contains(image, ":") {
-		tag = strings.SplitAfter(image, ":")[1]
+// IsUpToDate reports whether the image in ECR described by 'remote' is the same as 'image' on the Docker host, either
+// because they share a config digest or because the image was already pushed to (or pulled from) 'repositoryURI' with
+// the remote manifest digest
+func IsUpToDate(dockerClient Client, image string, repositoryURI string, remote *ecr.ImageManifest) (bool, error) {
+	details, err := dockerClient.ImageInspect(image)
+	if err != nil {
+		return false, err
+	}
+
+	for _, repoDigest := range details.RepoDigests {
+		if repoDigest == repositoryURI+"@"+remote.Digest {
+			return true, nil
+		}
+	}
+
+	configDigest, err := remote.ConfigDigest()
+	if err != nil {
+		return false, err
 	}
 
-	if tag != "" {
-		return repositoryURI + ":" + tag
+	return configDigest == details.ID, nil
+}
+
+// getTargetImageReferences returns the references in the ECR repository that 'image' is pushed to
+func getTargetImageReferences(repositoryURI string, image string, tags []string) []string {
+	if len(tags) == 0 {
+		return []string{getFullECRImageReference(repositoryURI, image)}
+	}
+
+	targets := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		targets = append(targets, repositoryURI+":"+tag)
+	}
+
+	return targets
+}
+
+// getStrippedImageReference returns the name that 'image' is tagged with on the Docker host when stripping ECR-specific
+// elements. Docker cannot create a tag that contains a digest, so the digest is dropped, and false is returned when
+// 'image' has no tag to use instead.
+func getStrippedImageReference(image string) (string, bool) {
+	ref, err := reference.Parse(image)
+	if err != nil || ref.Digest == "" {
+		return image, true
+	}
+
+	if ref.Tag == "" {
+		return "", false
+	}
+
+	return reference.Reference{Registry: ref.Registry, Path: ref.Path, Tag: ref.Tag}.String(), true
+}
+
+// getFullECRImageReference returns the reference to the tag and/or digest of 'image' within the ECR repository
+func getFullECRImageReference(repositoryURI string, image string) string {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return repositoryURI
 	}
 
-	return repositoryURI
+	return ref.InRepository(repositoryURI)
 }
 
 func encodeRegistryAuthentication(auth ecr.RegistryAuth) (string, error) {
@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hylandsoftware/trebuchet/internal/ecr"
@@ -18,9 +19,15 @@ func (m *mockDockerClient) ImageExists(image string) error {
 	return args.Error(0)
 }
 
-func (m *mockDockerClient) ImagePush(image string, auth ecr.RegistryAuth) error {
+func (m *mockDockerClient) ImageInspect(image string) (*ImageDetails, error) {
+	args := m.Called(image)
+	details, _ := args.Get(0).(*ImageDetails)
+	return details, args.Error(1)
+}
+
+func (m *mockDockerClient) ImagePush(image string, auth ecr.RegistryAuth) (string, error) {
 	args := m.Called(image, auth)
-	return args.Error(0)
+	return args.String(0), args.Error(1)
 }
 
 func (m *mockDockerClient) ImagePull(image string, auth ecr.RegistryAuth) error {
@@ -41,19 +48,20 @@ func (m *mockDockerClient) ImageRemove(image string) error {
 func TestDockerClient_Push_ValidPush(t *testing.T) {
 	m := &mockDockerClient{}
 	m.On("ImageTag", mock.Anything, mock.Anything).Return(nil)
-	m.On("ImagePush", mock.Anything, ecr.RegistryAuth{}).Return(nil)
+	m.On("ImagePush", mock.Anything, ecr.RegistryAuth{}).Return("sha256:digest", nil)
 	m.On("ImageRemove", mock.Anything).Return(nil)
 
-	err := TagAndPush(m, "", "", ecr.RegistryAuth{})
+	result, err := TagAndPush(m, "", "", nil, ecr.RegistryAuth{})
 
 	require.NoError(t, err)
+	require.Equal(t, "sha256:digest", result)
 }
 
 func TestDockerClient_Push_ImageTagReturnsError(t *testing.T) {
 	m := &mockDockerClient{}
 	m.On("ImageTag", mock.Anything, mock.Anything).Return(errors.New("error"))
 
-	err := TagAndPush(m, "", "", ecr.RegistryAuth{})
+	_, err := TagAndPush(m, "", "", nil, ecr.RegistryAuth{})
 
 	require.EqualError(t, err, "error")
 }
@@ -62,11 +70,133 @@ func TestDockerClient_Push_ImagePushReturnsError(t *testing.T) {
 	m := &mockDockerClient{}
 	m.On("ImageTag", mock.Anything, mock.Anything).Return(nil)
 	m.On("ImageRemove", mock.Anything).Return(nil)
-	m.On("ImagePush", mock.Anything, ecr.RegistryAuth{}).Return(errors.New("error"))
+	m.On("ImagePush", mock.Anything, ecr.RegistryAuth{}).Return("", errors.New("error"))
+
+	_, err := TagAndPush(m, "", "", nil, ecr.RegistryAuth{})
+
+	require.EqualError(t, err, "error")
+}
+
+func TestDockerClient_Push_ReturnsPushErrorWithRemoveErrors(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageTag", mock.Anything, mock.Anything).Return(nil)
+	m.On("ImagePush", "ecr.com/app:1.2.3", ecr.RegistryAuth{}).Return("", errors.New("push"))
+	m.On("ImageRemove", "ecr.com/app:1.2.3").Return(errors.New("remove"))
+	m.On("ImageRemove", "ecr.com/app:latest").Return(nil)
 
-	err := TagAndPush(m, "", "", ecr.RegistryAuth{})
+	_, err := TagAndPush(m, "app:build", "ecr.com/app", []string{"1.2.3", "latest"}, ecr.RegistryAuth{})
 
-	require.EqualError(t, err, "error: %!s(<nil>)")
+	require.EqualError(t, err, "push: remove")
+}
+
+func TestDockerClient_Push_KeepsRemoveErrorWhenLaterRemoveSucceeds(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageTag", mock.Anything, mock.Anything).Return(nil)
+	m.On("ImagePush", mock.Anything, ecr.RegistryAuth{}).Return("sha256:digest", nil)
+	m.On("ImageRemove", "ecr.com/app:1.2.3").Return(errors.New("remove"))
+	m.On("ImageRemove", "ecr.com/app:latest").Return(nil)
+
+	_, err := TagAndPush(m, "app:build", "ecr.com/app", []string{"1.2.3", "latest"}, ecr.RegistryAuth{})
+
+	require.EqualError(t, err, "remove")
+}
+
+func TestDockerClient_Push_PushesEveryTag(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageTag", "app:build", mock.Anything).Return(nil)
+	m.On("ImagePush", mock.Anything, ecr.RegistryAuth{}).Return("sha256:digest", nil)
+	m.On("ImageRemove", mock.Anything).Return(nil)
+
+	_, err := TagAndPush(m, "app:build", "ecr.com/app", []string{"1.2.3", "latest"}, ecr.RegistryAuth{})
+
+	require.NoError(t, err)
+	for _, target := range []string{"ecr.com/app:1.2.3", "ecr.com/app:latest"} {
+		m.AssertCalled(t, "ImageTag", "app:build", target)
+		m.AssertCalled(t, "ImagePush", target, ecr.RegistryAuth{})
+		m.AssertCalled(t, "ImageRemove", target)
+	}
+	m.AssertNotCalled(t, "ImagePush", "ecr.com/app:build", ecr.RegistryAuth{})
+}
+
+func TestDockerClient_Push_RemovesAllTagsWhenPushFails(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageTag", mock.Anything, mock.Anything).Return(nil)
+	m.On("ImagePush", "ecr.com/app:1.2.3", ecr.RegistryAuth{}).Return("", errors.New("error"))
+	m.On("ImageRemove", mock.Anything).Return(nil)
+
+	_, err := TagAndPush(m, "app:build", "ecr.com/app", []string{"1.2.3", "latest"}, ecr.RegistryAuth{})
+
+	require.Error(t, err)
+	m.AssertCalled(t, "ImageRemove", "ecr.com/app:1.2.3")
+	m.AssertCalled(t, "ImageRemove", "ecr.com/app:latest")
+	m.AssertNotCalled(t, "ImagePush", "ecr.com/app:latest", ecr.RegistryAuth{})
+}
+
+func TestDockerClient_Push_RemovesCreatedTagsWhenTaggingFails(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageTag", "app:build", "ecr.com/app:1.2.3").Return(nil)
+	m.On("ImageTag", "app:build", "ecr.com/app:latest").Return(errors.New("error"))
+	m.On("ImageRemove", mock.Anything).Return(nil)
+
+	_, err := TagAndPush(m, "app:build", "ecr.com/app", []string{"1.2.3", "latest"}, ecr.RegistryAuth{})
+
+	require.Error(t, err)
+	m.AssertCalled(t, "ImageRemove", "ecr.com/app:1.2.3")
+	m.AssertNotCalled(t, "ImageRemove", "ecr.com/app:latest")
+	m.AssertNotCalled(t, "ImagePush", mock.Anything, mock.Anything)
+}
+
+func TestDockerClient_Push_DoesNotRetagImageAlreadyNamedForECR(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImagePush", "ecr.com/app:1.0", ecr.RegistryAuth{}).Return("sha256:digest", nil)
+
+	_, err := TagAndPush(m, "ecr.com/app:1.0", "ecr.com/app", nil, ecr.RegistryAuth{})
+
+	require.NoError(t, err)
+	m.AssertNotCalled(t, "ImageTag", mock.Anything, mock.Anything)
+	m.AssertNotCalled(t, "ImageRemove", mock.Anything)
+}
+
+func TestDockerClient_IsUpToDate_MatchesRepoDigest(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageInspect", "app:1.0").Return(&ImageDetails{
+		ID:          "sha256:local",
+		RepoDigests: []string{"ecr.com/app@sha256:manifest"},
+	}, nil)
+
+	result, err := IsUpToDate(m, "app:1.0", "ecr.com/app", &ecr.ImageManifest{Digest: "sha256:manifest"})
+
+	require.NoError(t, err)
+	require.True(t, result)
+}
+
+func TestDockerClient_IsUpToDate_MatchesConfigDigest(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageInspect", "app:1.0").Return(&ImageDetails{ID: "sha256:config"}, nil)
+
+	result, err := IsUpToDate(m, "app:1.0", "ecr.com/app", &ecr.ImageManifest{
+		Digest:   "sha256:manifest",
+		Manifest: `{"config":{"digest":"sha256:config"}}`,
+	})
+
+	require.NoError(t, err)
+	require.True(t, result)
+}
+
+func TestDockerClient_IsUpToDate_ReturnsFalseForDifferentImage(t *testing.T) {
+	m := &mockDockerClient{}
+	m.On("ImageInspect", "app:1.0").Return(&ImageDetails{
+		ID:          "sha256:local",
+		RepoDigests: []string{"other.com/app@sha256:manifest"},
+	}, nil)
+
+	result, err := IsUpToDate(m, "app:1.0", "ecr.com/app", &ecr.ImageManifest{
+		Digest:   "sha256:manifest",
+		Manifest: `{"config":{"digest":"sha256:config"}}`,
+	})
+
+	require.NoError(t, err)
+	require.False(t, result)
 }
 
 func TestDockerClient_Pull_ValidPull(t *testing.T) {
@@ -88,6 +218,31 @@ func TestDockerClient_Pull_ImageTagReturnsError(t *testing.T) {
 	require.EqualError(t, err, "error")
 }
 
+func TestDockerClient_Pull_StripsDigestFromLocalTag(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	m := &mockDockerClient{}
+	m.On("ImagePull", "ecr.com/app:1.0@"+digest, ecr.RegistryAuth{}).Return(nil)
+	m.On("ImageTag", "ecr.com/app:1.0@"+digest, "app:1.0").Return(nil)
+	m.On("ImageRemove", "ecr.com/app:1.0@"+digest).Return(nil)
+
+	err := Pull(m, "app:1.0@"+digest, "ecr.com/app", true, ecr.RegistryAuth{})
+
+	require.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestDockerClient_Pull_DigestOnlyPullIsNotTagged(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	m := &mockDockerClient{}
+	m.On("ImagePull", "ecr.com/app@"+digest, ecr.RegistryAuth{}).Return(nil)
+
+	err := Pull(m, "app@"+digest, "ecr.com/app", true, ecr.RegistryAuth{})
+
+	require.NoError(t, err)
+	m.AssertNotCalled(t, "ImageTag", mock.Anything, mock.Anything)
+	m.AssertNotCalled(t, "ImageRemove", mock.Anything)
+}
+
 func TestDockerClient_Pull_ImagePullReturnsError(t *testing.T) {
 	m := &mockDockerClient{}
 	m.On("ImagePull", mock.Anything, ecr.RegistryAuth{}).Return(errors.New("error"))
@@ -109,6 +264,20 @@ func TestDockerClient_GetFullECRImageReference_NotEmptySuffixReturnsBothURIAndSu
 	require.Equal(t, "https://ecr.com/repository/image:v1.2.3", result)
 }
 
+func TestDockerClient_GetFullECRImageReference_LocalRegistryPortIsNotTreatedAsTag(t *testing.T) {
+	result := getFullECRImageReference("https://ecr.com/repository/image", "localhost:5000/image:1.0")
+
+	require.Equal(t, "https://ecr.com/repository/image:1.0", result)
+}
+
+func TestDockerClient_GetFullECRImageReference_KeepsDigest(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	result := getFullECRImageReference("https://ecr.com/repository/image", "image@"+digest)
+
+	require.Equal(t, "https://ecr.com/repository/image@"+digest, result)
+}
+
 func TestEncodeRegistryAuthentication_ValidAuth(t *testing.T) {
 	auth := ecr.RegistryAuth{
 		Username: "AWS",
@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/term"
+)
+
+const (
+	// ProgressAuto uses ProgressTTY when stderr is a terminal and ProgressPlain otherwise
+	ProgressAuto = "auto"
+
+	// ProgressTTY redraws the progress bar of every layer in place, like the Docker CLI
+	ProgressTTY = "tty"
+
+	// ProgressPlain prints a line whenever the status of a layer changes, without progress bars
+	ProgressPlain = "plain"
+
+	// ProgressJSON prints the JSON messages of the Docker daemon, one per line
+	ProgressJSON = "json"
+
+	// ProgressNone prints no progress
+	ProgressNone = "none"
+)
+
+var ErrUnknownProgressMode = errors.New("progress must be one of auto, tty, plain, json or none")
+
+// progressDisplay writes the JSON message stream of a push or pull to 'out' in one of the progress modes
+type progressDisplay struct {
+	out  io.Writer
+	fd   uintptr
+	mode string
+}
+
+// newProgressDisplay returns a progress display that writes to stderr, resolving ProgressAuto based on whether
+// stderr is a terminal
+func newProgressDisplay(mode string) (*progressDisplay, error) {
+	fd, isTerminal := term.GetFdInfo(os.Stderr)
+
+	switch mode {
+	case ProgressAuto, "":
+		mode = ProgressPlain
+		if isTerminal {
+			mode = ProgressTTY
+		}
+	case ProgressTTY, ProgressPlain, ProgressJSON, ProgressNone:
+	default:
+		return nil, ErrUnknownProgressMode
+	}
+
+	return &progressDisplay{out: os.Stderr, fd: fd, mode: mode}, nil
+}
+
+// display writes the messages in 'in' until the stream ends, returning the first error reported by the Docker daemon.
+// Messages with auxiliary data, such as the digest of a pushed image, are passed to 'auxCallback'.
+func (p *progressDisplay) display(in io.Reader, auxCallback func(jsonmessage.JSONMessage)) error {
+	if p.mode == ProgressTTY {
+		return jsonmessage.DisplayJSONMessagesStream(in, p.out, p.fd, true, auxCallback)
+	}
+
+	decoder := json.NewDecoder(in)
+	encoder := json.NewEncoder(p.out)
+	statuses := map[string]string{}
+
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if p.mode == ProgressJSON {
+			if err := encoder.Encode(message); err != nil {
+				return err
+			}
+		}
+
+		if message.Error != nil {
+			return message.Error
+		}
+
+		if message.Aux != nil {
+			if auxCallback != nil {
+				auxCallback(message)
+			}
+			continue
+		}
+
+		if p.mode != ProgressPlain || message.Status == "" {
+			continue
+		}
+
+		if message.ID == "" {
+			fmt.Fprintln(p.out, message.Status)
+			continue
+		}
+
+		if statuses[message.ID] != message.Status {
+			statuses[message.ID] = message.Status
+			fmt.Fprintf(p.out, "%s: %s\n", message.ID, message.Status)
+		}
+	}
+}
+
+// push displays the progress of a push and returns the digest that the Docker daemon reports once the manifest has
+// been pushed
+func (p *progressDisplay) push(in io.Reader) (string, error) {
+	var digest string
+	err := p.display(in, func(message jsonmessage.JSONMessage) {
+		var result types.PushResult
+		if err := json.Unmarshal(*message.Aux, &result); err == nil && result.Digest != "" {
+			digest = result.Digest
+		}
+	})
+
+	return digest, err
+}
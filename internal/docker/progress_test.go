@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const pushStream = `{"status":"The push refers to repository [ecr.com/app]"}
+{"status":"Preparing","progressDetail":{},"id":"abc123"}
+{"status":"Pushing","progressDetail":{"current":512,"total":1024},"progress":"[====>    ]","id":"abc123"}
+{"status":"Pushing","progressDetail":{"current":1024,"total":1024},"progress":"[=========>]","id":"abc123"}
+{"status":"Pushed","progressDetail":{},"id":"abc123"}
+{"status":"1.0: digest: sha256:manifest size: 528"}
+{"progressDetail":{},"aux":{"Tag":"1.0","Digest":"sha256:manifest","Size":528}}
+`
+
+func TestDockerProgress_Push_ReturnsDigest(t *testing.T) {
+	display := &progressDisplay{out: ioutil.Discard, mode: ProgressNone}
+
+	result, err := display.push(strings.NewReader(pushStream))
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:manifest", result)
+}
+
+func TestDockerProgress_Display_PlainPrintsStatusChanges(t *testing.T) {
+	var out bytes.Buffer
+	display := &progressDisplay{out: &out, mode: ProgressPlain}
+
+	err := display.display(strings.NewReader(pushStream), nil)
+
+	require.NoError(t, err)
+	require.Equal(t, `The push refers to repository [ecr.com/app]
+abc123: Preparing
+abc123: Pushing
+abc123: Pushed
+1.0: digest: sha256:manifest size: 528
+`, out.String())
+}
+
+func TestDockerProgress_Display_JSONPrintsEveryMessage(t *testing.T) {
+	var out bytes.Buffer
+	display := &progressDisplay{out: &out, mode: ProgressJSON}
+
+	err := display.display(strings.NewReader(pushStream), nil)
+
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), 7)
+	require.Contains(t, out.String(), `"id":"abc123"`)
+}
+
+func TestDockerProgress_Display_NonePrintsNothing(t *testing.T) {
+	var out bytes.Buffer
+	display := &progressDisplay{out: &out, mode: ProgressNone}
+
+	err := display.display(strings.NewReader(pushStream), nil)
+
+	require.NoError(t, err)
+	require.Empty(t, out.String())
+}
+
+func TestDockerProgress_Display_ReturnsStreamError(t *testing.T) {
+	stream := `{"errorDetail":{"message":"denied"},"error":"denied"}
+`
+	display := &progressDisplay{out: ioutil.Discard, mode: ProgressNone}
+
+	err := display.display(strings.NewReader(stream), nil)
+
+	require.EqualError(t, err, "denied")
+}
+
+func TestDockerProgress_NewProgressDisplay_RejectsUnknownMode(t *testing.T) {
+	_, err := newProgressDisplay("fancy")
+
+	require.Equal(t, ErrUnknownProgressMode, err)
+}
@@ -0,0 +1,163 @@
+package dockerconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const configFileName = "config.json"
+
+// Config is a Docker CLI configuration file. Only the registry credentials are interpreted, every other setting is
+// written back unchanged.
+type Config struct {
+	path   string
+	fields map[string]json.RawMessage
+	auths  map[string]json.RawMessage
+}
+
+// Dir returns the directory of the Docker CLI configuration, which is $DOCKER_CONFIG or ~/.docker
+func Dir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".docker"), nil
+}
+
+// Load reads the configuration file in 'dir'. A missing file results in an empty configuration.
+func Load(dir string) (*Config, error) {
+	config := &Config{
+		path:   filepath.Join(dir, configFileName),
+		fields: map[string]json.RawMessage{},
+		auths:  map[string]json.RawMessage{},
+	}
+
+	content, err := ioutil.ReadFile(config.path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return config, nil
+	}
+
+	if err := json.Unmarshal(content, &config.fields); err != nil {
+		return nil, err
+	}
+
+	if auths, ok := config.fields["auths"]; ok {
+		if err := json.Unmarshal(auths, &config.auths); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// Path returns the path of the configuration file
+func (c *Config) Path() string {
+	return c.path
+}
+
+// SetAuth stores the credentials for the registry, replacing any existing credentials for it
+func (c *Config) SetAuth(registry string, username string, password string) error {
+	c.RemoveAuth(registry)
+
+	entry, err := json.Marshal(map[string]string{
+		"auth": base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.auths[registry] = entry
+	return nil
+}
+
+// RemoveAuth removes the credentials for the registry, whether they are stored under its host name or under a URL
+// of the registry. It returns false if there were no credentials for the registry.
+func (c *Config) RemoveAuth(registry string) bool {
+	removed := false
+	for key := range c.auths {
+		if HostName(key) == HostName(registry) {
+			delete(c.auths, key)
+			removed = true
+		}
+	}
+
+	return removed
+}
+
+// CredentialsStore returns the name of the credential helper that the Docker CLI uses for the registry instead of
+// the credentials in the configuration file, or an empty string if there is none
+func (c *Config) CredentialsStore(registry string) string {
+	var helpers map[string]string
+	if err := json.Unmarshal(c.fields["credHelpers"], &helpers); err == nil {
+		if helper, ok := helpers[HostName(registry)]; ok {
+			return helper
+		}
+	}
+
+	var store string
+	_ = json.Unmarshal(c.fields["credsStore"], &store)
+	return store
+}
+
+// Save writes the configuration file, creating its directory if needed. The file is replaced atomically and is
+// only readable by the current user, as it contains credentials.
+func (c *Config) Save() error {
+	auths, err := json.Marshal(c.auths)
+	if err != nil {
+		return err
+	}
+	c.fields["auths"] = auths
+
+	content, err := json.MarshalIndent(c.fields, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(dir, configFileName)
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0600)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), c.path)
+}
+
+// HostName returns the host name of a registry given either as a host name or as a URL, which is how the Docker CLI
+// matches credentials to registries
+func HostName(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	return strings.SplitN(registry, "/", 2)[0]
+}
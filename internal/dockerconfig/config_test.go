@@ -0,0 +1,126 @@
+package dockerconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const registry = "112233445566.dkr.ecr.us-east-1.amazonaws.com"
+
+func TestDockerConfig_Dir_UsesDockerConfigVariable(t *testing.T) {
+	defer os.Setenv("DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	os.Setenv("DOCKER_CONFIG", "/tmp/docker")
+
+	result, err := Dir()
+
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/docker", result)
+}
+
+func TestDockerConfig_Load_MissingFileIsEmpty(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	config, err := Load(dir)
+
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "config.json"), config.Path())
+	require.False(t, config.RemoveAuth(registry))
+}
+
+func TestDockerConfig_Save_PreservesOtherSettings(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeConfig(t, dir, `{
+		"auths": {"other.example.com": {"auth": "b3RoZXI6c2VjcmV0", "email": "me@example.com"}},
+		"psFormat": "table {{.ID}}",
+		"proxies": {"default": {"httpProxy": "http://proxy:3128"}}
+	}`)
+
+	config, err := Load(dir)
+	require.NoError(t, err)
+	require.NoError(t, config.SetAuth(registry, "AWS", "token"))
+	require.NoError(t, config.Save())
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"auths": {
+			"other.example.com": {"auth": "b3RoZXI6c2VjcmV0", "email": "me@example.com"},
+			"112233445566.dkr.ecr.us-east-1.amazonaws.com": {"auth": "QVdTOnRva2Vu"}
+		},
+		"psFormat": "table {{.ID}}",
+		"proxies": {"default": {"httpProxy": "http://proxy:3128"}}
+	}`, string(content))
+}
+
+func TestDockerConfig_Save_IsOnlyReadableByOwner(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	config, err := Load(filepath.Join(dir, "docker"))
+	require.NoError(t, err)
+	require.NoError(t, config.SetAuth(registry, "AWS", "token"))
+	require.NoError(t, config.Save())
+
+	info, err := os.Stat(config.Path())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestDockerConfig_SetAuth_ReplacesURLEntry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeConfig(t, dir, `{"auths": {"https://`+registry+`": {"auth": "b2xkOm9sZA=="}}}`)
+
+	config, err := Load(dir)
+	require.NoError(t, err)
+	require.NoError(t, config.SetAuth(registry, "AWS", "token"))
+	require.NoError(t, config.Save())
+
+	content, err := ioutil.ReadFile(config.Path())
+	require.NoError(t, err)
+	require.JSONEq(t, `{"auths": {"`+registry+`": {"auth": "QVdTOnRva2Vu"}}}`, string(content))
+}
+
+func TestDockerConfig_RemoveAuth_RemovesRegistry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeConfig(t, dir, `{"auths": {"`+registry+`": {"auth": "QVdTOnRva2Vu"}, "other.example.com": {}}}`)
+
+	config, err := Load(dir)
+	require.NoError(t, err)
+
+	require.True(t, config.RemoveAuth("https://"+registry))
+	require.NoError(t, config.Save())
+
+	content, err := ioutil.ReadFile(config.Path())
+	require.NoError(t, err)
+	require.JSONEq(t, `{"auths": {"other.example.com": {}}}`, string(content))
+}
+
+func TestDockerConfig_CredentialsStore_PrefersCredentialHelper(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeConfig(t, dir, `{"credsStore": "desktop", "credHelpers": {"`+registry+`": "ecr-login"}}`)
+
+	config, err := Load(dir)
+	require.NoError(t, err)
+
+	require.Equal(t, "ecr-login", config.CredentialsStore(registry))
+	require.Equal(t, "desktop", config.CredentialsStore("other.example.com"))
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dockerconfig")
+	require.NoError(t, err)
+	return dir
+}
+
+func writeConfig(t *testing.T, dir string, content string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600))
+}
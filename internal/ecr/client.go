@@ -2,10 +2,15 @@ package ecr
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/awserr"
@@ -13,50 +18,179 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	"github.com/hylandsoftware/trebuchet/internal/logging"
+	"github.com/hylandsoftware/trebuchet/internal/sso"
 	"github.com/hylandsoftware/trebuchet/internal/sts"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	ErrNoTokenOrProxyEndpoint  = errors.New("no authorization token or proxy endpoint obtained when requesting token")
-	ErrNoCredentials           = errors.New("no credentials provided")
+	ErrNoTokenOrProxyEndpoint = errors.New("no authorization token or proxy endpoint obtained when requesting token")
+	ErrNoCredentials          = errors.New("no credentials provided")
+	ErrNoUploadID             = errors.New("no upload ID obtained when initiating layer upload")
+	ErrImageNotFound          = errors.New("image not found in repository")
+	ErrNoDownloadURL          = errors.New("no download URL obtained for layer")
+	ErrForeignRegistry        = errors.New("repositories can only be created in the registry of the caller's account")
+	ErrTagDigestMismatch      = errors.New("tag does not refer to the pushed image")
+	ErrNoWebIdentityRole      = errors.New("a role to assume is required with a web identity token")
+	ErrRegistryIDMismatch     = errors.New("registry ID does not match the registry of the ECR URI")
 )
 
+// acceptedManifestMediaTypes are the single-image manifest formats trebuchet can read from ECR
+var acceptedManifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// defaultLayerPartSize is used when ECR does not return a part size for a layer upload
+const defaultLayerPartSize = 10 * 1024 * 1024
+
 type Client interface {
 	RepositoryExists(repository string) (bool, error)
 	CreateRepository(repository string) error
 	GetRepositoryURI(repository string) (string, error)
 	GetAuthorizationToken() (*RegistryAuth, error)
+	LayerExists(repository string, digest string) (bool, error)
+	UploadLayer(repository string, digest string, layer io.Reader) error
+	PutImage(repository string, tag string, manifest string, mediaType string) (string, error)
+	GetImageManifest(repository string, reference string) (*ImageManifest, error)
+	GetLayerURL(repository string, digest string) (string, error)
+	ListImageTags(repository string) ([]string, error)
 }
 
 type RegistryAuth struct {
 	ProxyEndpoint string
 	Username      string
 	Password      string
+	ExpiresAt     time.Time
+}
+
+// ImageManifest is the manifest of an image stored in ECR, along with its digest and media type
+type ImageManifest struct {
+	Digest    string
+	MediaType string
+	Manifest  string
+}
+
+// ConfigDigest returns the digest of the image config referenced by the manifest, which is also the image ID that
+// Docker uses for the image
+func (m ImageManifest) ConfigDigest() (string, error) {
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+
+	if err := json.Unmarshal([]byte(m.Manifest), &manifest); err != nil {
+		return "", err
+	}
+
+	return manifest.Config.Digest, nil
+}
+
+// Options configures how an ECR client authenticates and which registry it talks to
+type Options struct {
+	Region string
+	// AssumeRoles are the roles to assume in sequence, each with the credentials of the previous one
+	AssumeRoles []string
+	Profile     string
+	// WebIdentityTokenFile is a file with an OpenID Connect token to assume the role with. When empty,
+	// $AWS_WEB_IDENTITY_TOKEN_FILE is used if it is set.
+	WebIdentityTokenFile string
+	// RoleOptions are the session name, external ID, duration, MFA device, session tags and source identity used to
+	// assume roles
+	RoleOptions sts.AssumeRoleOptions
+	// RegistryID is the AWS account ID of the registry. When empty, the registry of the caller's account is used.
+	RegistryID string
+	// Cache stores authorization tokens and assumed role credentials until they expire. When nil, nothing is cached.
+	Cache *cache.Cache
 }
 
 type ecrClient struct {
 	*ecr.Client
-	log *log.Entry
+	log           *log.Entry
+	registry      string
+	cache         *cache.Cache
+	callerAccount func() (string, error)
 }
 
-func NewClient(region string, assumeRole string, profile string) (Client, error) {
-	config, err := getClientConfig(region, assumeRole, profile, sts.NewRoleAssumer(), external.LoadDefaultAWSConfig)
+func NewClient(options Options) (Client, error) {
+	assumer := sts.NewRoleAssumer()
+	if options.Cache != nil {
+		assumer = sts.NewCachingRoleAssumer(assumer, options.Cache)
+	}
+
+	config, err := getClientConfig(options, assumer, withSSO(external.LoadDefaultAWSConfig))
 	if err != nil {
 		return nil, err
 	}
 
+	entry := log.WithFields(log.Fields{
+		"component": "ecr",
+		"region":    config.Region,
+	})
+
+	client := ecr.New(config)
+	client.Handlers.Complete.PushBack(logging.RequestLogger(entry))
+
 	return &ecrClient{
-		Client: ecr.New(config),
-		log: log.WithField("component", "ecr"),
+		Client:   client,
+		log:      entry,
+		registry: options.RegistryID,
+		cache:    options.Cache,
+		callerAccount: func() (string, error) {
+			return sts.CallerAccount(config)
+		},
 	}, nil
 }
 
 type configLoaderFunc func(configs ...external.Config) (aws.Config, error)
 
+// withSSO wraps a config loader so that profiles that sign in with AWS IAM Identity Center (SSO) use the credentials
+// of their account and role, which this version of the AWS SDK does not support. Profiles with credential_process
+// are supported by the SDK itself.
+func withSSO(configLoader configLoaderFunc) configLoaderFunc {
+	return func(configs ...external.Config) (aws.Config, error) {
+		cfg, err := configLoader(configs...)
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		profile := sharedConfigProfile(cfg)
+		if profile == "" {
+			return cfg, nil
+		}
+
+		path, err := sso.ConfigFile()
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		ssoProfile, err := sso.LoadProfile(path, profile)
+		if err != nil || ssoProfile == nil {
+			return cfg, err
+		}
+
+		cacheDir, err := sso.CacheDir()
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		log.WithField("profile", profile).Debug("Using SSO credentials of profile")
+		cfg.Credentials, err = sso.NewCredentialsProvider(cfg, *ssoProfile, cacheDir)
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		return cfg, nil
+	}
+}
+
 func (c *ecrClient) RepositoryExists(repository string) (bool, error) {
 	_, err := c.DescribeRepositoriesRequest(&ecr.DescribeRepositoriesInput{
 		RepositoryNames: []string{repository},
+		RegistryId:      c.registryID(),
 	}).Send(context.Background())
 
 	if err != nil {
@@ -72,6 +206,17 @@ func (c *ecrClient) RepositoryExists(repository string) (bool, error) {
 }
 
 func (c *ecrClient) CreateRepository(repository string) error {
+	// ECR always creates repositories in the caller's account, so refuse rather than create it in the wrong registry
+	if c.registry != "" {
+		account, err := c.callerAccount()
+		if err != nil {
+			return err
+		}
+		if account != c.registry {
+			return ErrForeignRegistry
+		}
+	}
+
 	_, err := c.CreateRepositoryRequest(&ecr.CreateRepositoryInput{
 		RepositoryName: &repository,
 	}).Send(context.Background())
@@ -88,6 +233,7 @@ func (c *ecrClient) CreateRepository(repository string) error {
 func (c *ecrClient) GetRepositoryURI(repository string) (string, error) {
 	result, err := c.DescribeRepositoriesRequest(&ecr.DescribeRepositoriesInput{
 		RepositoryNames: []string{repository},
+		RegistryId:      c.registryID(),
 	}).Send(context.Background())
 
 	if err != nil {
@@ -98,9 +244,40 @@ func (c *ecrClient) GetRepositoryURI(repository string) (string, error) {
 	return *result.Repositories[0].RepositoryUri, nil
 }
 
+// GetAuthorizationToken returns credentials for the registry. When the client has a cache, the token is reused until
+// it is about to expire, per region, registry and the AWS credentials the token was requested with.
 func (c *ecrClient) GetAuthorizationToken() (*RegistryAuth, error) {
+	if c.cache == nil {
+		return c.getAuthorizationToken()
+	}
+
+	credentials, err := c.Config.Credentials.Retrieve(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &RegistryAuth{}
+	err = c.cache.GetOrCreate([]string{"ecr", c.Config.Region, c.registry, credentials.AccessKeyID}, auth, func() (time.Time, error) {
+		token, err := c.getAuthorizationToken()
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		*auth = *token
+		return token.ExpiresAt, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func (c *ecrClient) getAuthorizationToken() (*RegistryAuth, error) {
 	c.log.Debug("Getting authorization token")
-	result, err := c.GetAuthorizationTokenRequest(&ecr.GetAuthorizationTokenInput{}).Send(context.Background())
+	result, err := c.GetAuthorizationTokenRequest(&ecr.GetAuthorizationTokenInput{
+		RegistryIds: c.registryIDs(),
+	}).Send(context.Background())
 
 	if err != nil {
 		return nil, err
@@ -116,9 +293,230 @@ func (c *ecrClient) GetAuthorizationToken() (*RegistryAuth, error) {
 	if err != nil {
 		return nil, err
 	}
+	auth.ExpiresAt = aws.TimeValue(authorizationData.ExpiresAt)
 	return auth, nil
 }
 
+// LayerExists checks whether a layer (or config blob) with the given digest has already been uploaded to the repository
+func (c *ecrClient) LayerExists(repository string, digest string) (bool, error) {
+	result, err := c.BatchCheckLayerAvailabilityRequest(&ecr.BatchCheckLayerAvailabilityInput{
+		RepositoryName: &repository,
+		RegistryId:     c.registryID(),
+		LayerDigests:   []string{digest},
+	}).Send(context.Background())
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, layer := range result.Layers {
+		if aws.StringValue(layer.LayerDigest) == digest && layer.LayerAvailability == ecr.LayerAvailabilityAvailable {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// UploadLayer uploads a layer (or config blob) to the repository in parts, using the part size requested by ECR
+func (c *ecrClient) UploadLayer(repository string, digest string, layer io.Reader) error {
+	initiated, err := c.InitiateLayerUploadRequest(&ecr.InitiateLayerUploadInput{
+		RepositoryName: &repository,
+		RegistryId:     c.registryID(),
+	}).Send(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	if initiated.UploadId == nil {
+		return ErrNoUploadID
+	}
+
+	partSize := aws.Int64Value(initiated.PartSize)
+	if partSize <= 0 {
+		partSize = defaultLayerPartSize
+	}
+
+	c.log.WithFields(log.Fields{
+		"repository": repository,
+		"digest":     digest,
+	}).Info("Uploading layer")
+
+	buffer := make([]byte, partSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(layer, buffer)
+		if n > 0 {
+			_, err := c.UploadLayerPartRequest(&ecr.UploadLayerPartInput{
+				RepositoryName: &repository,
+				RegistryId:     c.registryID(),
+				UploadId:       initiated.UploadId,
+				LayerPartBlob:  buffer[:n],
+				PartFirstByte:  aws.Int64(offset),
+				PartLastByte:   aws.Int64(offset + int64(n) - 1),
+			}).Send(context.Background())
+
+			if err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	_, err = c.CompleteLayerUploadRequest(&ecr.CompleteLayerUploadInput{
+		RepositoryName: &repository,
+		RegistryId:     c.registryID(),
+		UploadId:       initiated.UploadId,
+		LayerDigests:   []string{digest},
+	}).Send(context.Background())
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeLayerAlreadyExistsException {
+			c.log.WithField("digest", digest).Debug("Layer already exists")
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PutImage creates or updates the image manifest and tag in the repository, returning the digest of the manifest
+func (c *ecrClient) PutImage(repository string, tag string, manifest string, mediaType string) (string, error) {
+	input := &ecr.PutImageInput{
+		RepositoryName: &repository,
+		RegistryId:     c.registryID(),
+		ImageManifest:  &manifest,
+	}
+	if tag != "" {
+		input.ImageTag = &tag
+	}
+	if mediaType != "" {
+		input.ImageManifestMediaType = &mediaType
+	}
+
+	result, err := c.PutImageRequest(input).Send(context.Background())
+
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeImageAlreadyExistsException {
+			c.log.WithFields(log.Fields{
+				"repository": repository,
+				"tag":        tag,
+			}).Info("Image is already up to date")
+			return manifestDigest(manifest), nil
+		}
+		return "", err
+	}
+
+	digest := manifestDigest(manifest)
+	if result.Image != nil && result.Image.ImageId != nil && result.Image.ImageId.ImageDigest != nil {
+		digest = *result.Image.ImageId.ImageDigest
+	}
+
+	c.log.WithFields(log.Fields{
+		"repository": repository,
+		"tag":        tag,
+		"digest":     digest,
+	}).Info("Successfully put image")
+	return digest, nil
+}
+
+// GetImageManifest retrieves the manifest of the image in the repository identified by 'reference', which is either a
+// tag or a digest
+func (c *ecrClient) GetImageManifest(repository string, reference string) (*ImageManifest, error) {
+	imageID := ecr.ImageIdentifier{ImageTag: aws.String(reference)}
+	if strings.HasPrefix(reference, "sha256:") {
+		imageID = ecr.ImageIdentifier{ImageDigest: aws.String(reference)}
+	}
+
+	result, err := c.BatchGetImageRequest(&ecr.BatchGetImageInput{
+		RepositoryName:     &repository,
+		RegistryId:         c.registryID(),
+		ImageIds:           []ecr.ImageIdentifier{imageID},
+		AcceptedMediaTypes: acceptedManifestMediaTypes,
+	}).Send(context.Background())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Images) == 0 || result.Images[0].ImageManifest == nil {
+		c.log.WithFields(log.Fields{
+			"repository": repository,
+			"reference":  reference,
+		}).Debug("Image does not exist")
+		return nil, ErrImageNotFound
+	}
+
+	image := result.Images[0]
+	manifest := &ImageManifest{
+		MediaType: aws.StringValue(image.ImageManifestMediaType),
+		Manifest:  *image.ImageManifest,
+	}
+	if image.ImageId != nil && image.ImageId.ImageDigest != nil {
+		manifest.Digest = *image.ImageId.ImageDigest
+	} else {
+		manifest.Digest = manifestDigest(manifest.Manifest)
+	}
+
+	return manifest, nil
+}
+
+// GetLayerURL returns a pre-signed URL from which the layer (or config blob) with the given digest can be downloaded
+func (c *ecrClient) GetLayerURL(repository string, digest string) (string, error) {
+	result, err := c.GetDownloadUrlForLayerRequest(&ecr.GetDownloadUrlForLayerInput{
+		RepositoryName: &repository,
+		RegistryId:     c.registryID(),
+		LayerDigest:    &digest,
+	}).Send(context.Background())
+
+	if err != nil {
+		return "", err
+	}
+
+	if result.DownloadUrl == nil {
+		return "", ErrNoDownloadURL
+	}
+
+	return *result.DownloadUrl, nil
+}
+
+// ListImageTags returns every tag of every image in the repository
+func (c *ecrClient) ListImageTags(repository string) ([]string, error) {
+	paginator := ecr.NewListImagesPaginator(c.ListImagesRequest(&ecr.ListImagesInput{
+		RepositoryName: &repository,
+		RegistryId:     c.registryID(),
+		Filter:         &ecr.ListImagesFilter{TagStatus: ecr.TagStatusTagged},
+	}))
+
+	var tags []string
+	for paginator.Next(context.Background()) {
+		for _, imageID := range paginator.CurrentPage().ImageIds {
+			if imageID.ImageTag != nil {
+				tags = append(tags, *imageID.ImageTag)
+			}
+		}
+	}
+
+	if err := paginator.Err(); err != nil {
+		return nil, err
+	}
+
+	c.log.WithFields(log.Fields{
+		"repository": repository,
+		"tags":       len(tags),
+	}).Debug("Listed image tags")
+	return tags, nil
+}
+
 // SetupRepository will check if a repository exists, create it if it does not,
 // and then return the repository URI to access to repository.
 func SetupRepository(c Client, repository string) (string, error) {
@@ -141,6 +539,69 @@ func SetupRepository(c Client, repository string) (string, error) {
 	return repositoryURI, nil
 }
 
+// OutdatedTags returns the tags that do not exist in the repository yet, or that refer to an image for which
+// 'upToDate' returns false
+func OutdatedTags(c Client, repository string, tags []string, upToDate func(remote *ImageManifest) (bool, error)) ([]string, error) {
+	var outdated []string
+	for _, tag := range tags {
+		remote, err := c.GetImageManifest(repository, tag)
+		if err == ErrImageNotFound {
+			outdated = append(outdated, tag)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		same, err := upToDate(remote)
+		if err != nil {
+			return nil, err
+		}
+
+		if same {
+			log.WithFields(log.Fields{
+				"component":  "ecr",
+				"repository": repository,
+				"tag":        tag,
+				"digest":     remote.Digest,
+			}).Info("Tag is up to date")
+			continue
+		}
+
+		outdated = append(outdated, tag)
+	}
+
+	return outdated, nil
+}
+
+// VerifyDigest checks that each of the tags in the repository refers to the manifest with the given digest, and returns
+// that digest. When 'digest' is empty, the digest of the first tag is used instead.
+func VerifyDigest(c Client, repository string, tags []string, digest string) (string, error) {
+	for _, tag := range tags {
+		remote, err := c.GetImageManifest(repository, tag)
+		if err != nil {
+			return "", err
+		}
+
+		if digest == "" {
+			digest = remote.Digest
+		}
+
+		if remote.Digest != digest {
+			log.WithFields(log.Fields{
+				"component":  "ecr",
+				"repository": repository,
+				"tag":        tag,
+				"expected":   digest,
+				"actual":     remote.Digest,
+			}).Error("Tag refers to a different digest")
+			return "", ErrTagDigestMismatch
+		}
+	}
+
+	return digest, nil
+}
+
 func extractToken(token string, proxyEndpoint string) (*RegistryAuth, error) {
 	decodedToken, err := base64.StdEncoding.DecodeString(token)
 	if err != nil {
@@ -159,32 +620,104 @@ func extractToken(token string, proxyEndpoint string) (*RegistryAuth, error) {
 	}, nil
 }
 
-func getClientConfig(region string, assumeRole string, profile string, assumer sts.RoleAssumer, configLoader configLoaderFunc) (cfg aws.Config, err error) {
-	if profile == "" {
+// registryID returns the registry ID to set on requests, or nil to use the registry of the caller's account
+func (c *ecrClient) registryID() *string {
+	if c.registry == "" {
+		return nil
+	}
+	return aws.String(c.registry)
+}
+
+func (c *ecrClient) registryIDs() []string {
+	if c.registry == "" {
+		return nil
+	}
+	return []string{c.registry}
+}
+
+func manifestDigest(manifest string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest)))
+}
+
+func getClientConfig(options Options, assumer sts.RoleAssumer, configLoader configLoaderFunc) (cfg aws.Config, err error) {
+	if options.Profile == "" {
 		cfg, err = configLoader()
 		if err != nil {
 			return aws.Config{}, err
 		}
 	} else {
-		log.WithField("profile", profile).Debug("Explicitly setting profile")
-		cfg, err = configLoader(external.WithSharedConfigProfile(profile))
+		log.WithField("profile", options.Profile).Debug("Explicitly setting profile")
+		cfg, err = configLoader(external.WithSharedConfigProfile(options.Profile))
 		if err != nil {
 			return aws.Config{}, err
 		}
 	}
 
+	if options.Region != "" {
+		log.WithField("region", options.Region).Debug("Explicitly setting region")
+		cfg.Region = options.Region
+	}
+
+	roles := options.AssumeRoles
+
+	// With a web identity token, the role of $AWS_ROLE_ARN or the first role to assume is assumed with the token
+	// instead of the credentials of the environment, and the other roles are assumed after it
+	tokenFile := options.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if tokenFile != "" {
+		webIdentityRole := os.Getenv("AWS_ROLE_ARN")
+		if webIdentityRole == "" && len(roles) > 0 {
+			webIdentityRole, roles = roles[0], roles[1:]
+		}
+		if webIdentityRole == "" {
+			return aws.Config{}, ErrNoWebIdentityRole
+		}
+
+		log.WithField("tokenFile", tokenFile).Debug("Assuming role with web identity")
+		newCredentials, err := assumer.AssumeRoleWithWebIdentity(cfg, webIdentityRole, tokenFile, options.RoleOptions)
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		cfg.Credentials = newCredentials
+	}
+
 	if cfg.Credentials == nil {
 		return aws.Config{}, ErrNoCredentials
 	}
 
-	if region != "" {
-		log.WithField("region", region).Debug("Explicitly setting region")
-		cfg.Region = region
+	// If the profile already assumed the first role, the chain continues from the profile's credentials
+	_, profileAssumedRole := cfg.Credentials.(*stscreds.AssumeRoleProvider)
+	if profileAssumedRole && len(roles) > 0 && roles[0] == profileRole(cfg) {
+		log.WithField("role", roles[0]).Debug("Role has already been assumed by the profile")
+		roles = roles[1:]
 	}
 
-	// If assumeRole is specified, assume that role - except for when a role has already been assumed.
-	if _, ok := cfg.Credentials.(*stscreds.AssumeRoleProvider); assumeRole != "" && !ok {
-		newCredentials, err := assumer.AssumeRole(cfg, assumeRole)
+	for i, role := range roles {
+		// MFA applies to the credentials of the environment and the source identity is kept throughout the chain once
+		// set. The duration only applies to a role assumed with credentials that are not those of another role, as AWS
+		// limits the sessions of roles assumed by other roles to one hour. The external ID and session tags are those
+		// of the target role.
+		roleOptions := options.RoleOptions
+		if i > 0 {
+			roleOptions.MFASerial = ""
+			roleOptions.MFAToken = nil
+		}
+		if i > 0 || tokenFile != "" {
+			roleOptions.SourceIdentity = ""
+		}
+		if i > 0 || tokenFile != "" || profileAssumedRole {
+			roleOptions.Duration = 0
+		}
+		if i < len(roles)-1 {
+			roleOptions.ExternalID = ""
+			roleOptions.Tags = nil
+			roleOptions.TransitiveTagKeys = nil
+		}
+
+		newCredentials, err := assumer.AssumeRole(cfg, role, roleOptions)
 
 		if err != nil {
 			return aws.Config{}, err
@@ -203,4 +736,25 @@ func getClientConfig(region string, assumeRole string, profile string, assumer s
 	return cfg, nil
 }
 
+// profileRole returns the role the shared configuration profile assumes, if any
+func profileRole(cfg aws.Config) string {
+	for _, source := range cfg.ConfigSources {
+		if sharedConfig, ok := source.(external.SharedConfig); ok {
+			return sharedConfig.RoleARN
+		}
+	}
 
+	return ""
+}
+
+// sharedConfigProfile returns the name of the shared configuration profile the configuration was loaded from, or an
+// empty string if the profile does not exist
+func sharedConfigProfile(cfg aws.Config) string {
+	for _, source := range cfg.ConfigSources {
+		if sharedConfig, ok := source.(external.SharedConfig); ok {
+			return sharedConfig.Profile
+		}
+	}
+
+	return ""
+}
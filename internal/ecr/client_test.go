@@ -1,14 +1,33 @@
 package ecr
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	"github.com/hylandsoftware/trebuchet/internal/logging"
 	"github.com/hylandsoftware/trebuchet/internal/sts"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -18,11 +37,23 @@ type mockRoleAssumer struct {
 	mock.Mock
 }
 
-func (m *mockRoleAssumer) AssumeRole(config aws.Config, arnRole string) (*sts.CredentialsProvider, error) {
-	args := m.Called(config, arnRole)
+func (m *mockRoleAssumer) AssumeRole(config aws.Config, arnRole string, options sts.AssumeRoleOptions) (*sts.CredentialsProvider, error) {
+	args := m.Called(config, arnRole, options)
 	return args.Get(0).(*sts.CredentialsProvider), args.Error(1)
 }
 
+func (m *mockRoleAssumer) AssumeRoleWithWebIdentity(config aws.Config, arnRole string, tokenFile string, options sts.AssumeRoleOptions) (*sts.CredentialsProvider, error) {
+	args := m.Called(config, arnRole, tokenFile, options)
+	return args.Get(0).(*sts.CredentialsProvider), args.Error(1)
+}
+
+// withCredentials matches configs with the given credentials provider
+func withCredentials(provider aws.CredentialsProvider) interface{} {
+	return mock.MatchedBy(func(config aws.Config) bool {
+		return config.Credentials == provider
+	})
+}
+
 type mockECRClient struct {
 	mock.Mock
 }
@@ -47,12 +78,44 @@ func (m *mockECRClient) GetAuthorizationToken() (*RegistryAuth, error) {
 	return args.Get(0).(*RegistryAuth), args.Error(1)
 }
 
+func (m *mockECRClient) LayerExists(repository string, digest string) (bool, error) {
+	args := m.Called(repository, digest)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockECRClient) UploadLayer(repository string, digest string, layer io.Reader) error {
+	args := m.Called(repository, digest, layer)
+	return args.Error(0)
+}
+
+func (m *mockECRClient) PutImage(repository string, tag string, manifest string, mediaType string) (string, error) {
+	args := m.Called(repository, tag, manifest, mediaType)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockECRClient) GetImageManifest(repository string, reference string) (*ImageManifest, error) {
+	args := m.Called(repository, reference)
+	manifest, _ := args.Get(0).(*ImageManifest)
+	return manifest, args.Error(1)
+}
+
+func (m *mockECRClient) GetLayerURL(repository string, digest string) (string, error) {
+	args := m.Called(repository, digest)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockECRClient) ListImageTags(repository string) ([]string, error) {
+	args := m.Called(repository)
+	tags, _ := args.Get(0).([]string)
+	return tags, args.Error(1)
+}
+
 func TestEcrClient_GetClientConfig_AssumeRoleUpdatesNewCredentials(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
-	m.On("AssumeRole", mock.Anything, "testing").Return(dummyCredProvider, nil)
+	m.On("AssumeRole", mock.Anything, "testing", mock.Anything).Return(dummyCredProvider, nil)
 
-	result, err := getClientConfig("us-east-1", "testing", "", m, func(configs ...external.Config) (aws.Config, error) {
+	result, err := getClientConfig(Options{Region: "us-east-1", AssumeRoles: []string{"testing"}}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "us-east-1",
 			Credentials: dummyCredProvider,
@@ -63,12 +126,127 @@ func TestEcrClient_GetClientConfig_AssumeRoleUpdatesNewCredentials(t *testing.T)
 	require.Equal(t, dummyCredProvider, result.Credentials)
 }
 
+func TestEcrClient_GetClientConfig_PassesRoleOptions(t *testing.T) {
+	m := &mockRoleAssumer{}
+	dummyCredProvider := &sts.CredentialsProvider{}
+	options := sts.AssumeRoleOptions{SessionName: "jenkins-app-42", ExternalID: "external-id", Duration: time.Hour}
+	m.On("AssumeRole", mock.Anything, "testing", options).Return(dummyCredProvider, nil)
+
+	_, err := getClientConfig(Options{Region: "us-east-1", AssumeRoles: []string{"testing"}, RoleOptions: options}, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Region:      "us-east-1",
+			Credentials: dummyCredProvider,
+		}, nil
+	})
+
+	require.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestEcrClient_GetClientConfig_ChainsRoles(t *testing.T) {
+	m := &mockRoleAssumer{}
+	sourceCredProvider := aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	ciCredProvider := &sts.CredentialsProvider{}
+	hubCredProvider := &sts.CredentialsProvider{}
+	pushCredProvider := &sts.CredentialsProvider{}
+	mfaToken := func() (string, error) { return "123456", nil }
+	m.On("AssumeRole", withCredentials(sourceCredProvider), "ci", mock.MatchedBy(func(options sts.AssumeRoleOptions) bool {
+		return options.MFASerial == "mfa" && options.ExternalID == "" && options.Duration == 2*time.Hour
+	})).Return(ciCredProvider, nil)
+	m.On("AssumeRole", withCredentials(ciCredProvider), "hub", mock.MatchedBy(func(options sts.AssumeRoleOptions) bool {
+		return options.MFASerial == "" && options.ExternalID == "" && options.Duration == 0
+	})).Return(hubCredProvider, nil)
+	m.On("AssumeRole", withCredentials(hubCredProvider), "push", mock.MatchedBy(func(options sts.AssumeRoleOptions) bool {
+		return options.MFASerial == "" && options.ExternalID == "external-id" && options.Duration == 0
+	})).Return(pushCredProvider, nil)
+	options := Options{
+		Region:      "us-east-1",
+		AssumeRoles: []string{"ci", "hub", "push"},
+		RoleOptions: sts.AssumeRoleOptions{MFASerial: "mfa", MFAToken: mfaToken, ExternalID: "external-id", Duration: 2 * time.Hour},
+	}
+
+	result, err := getClientConfig(options, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{Credentials: sourceCredProvider}, nil
+	})
+
+	require.NoError(t, err)
+	require.Same(t, pushCredProvider, result.Credentials)
+	m.AssertExpectations(t)
+}
+
+func TestEcrClient_GetClientConfig_PassesSessionTagsToLastAndSourceIdentityToFirstRole(t *testing.T) {
+	m := &mockRoleAssumer{}
+	hubCredProvider := &sts.CredentialsProvider{}
+	pushCredProvider := &sts.CredentialsProvider{}
+	tags := map[string]string{"team": "platform"}
+	m.On("AssumeRole", mock.Anything, "hub", mock.MatchedBy(func(options sts.AssumeRoleOptions) bool {
+		return options.SourceIdentity == "jenkins" && options.Tags == nil && options.TransitiveTagKeys == nil
+	})).Return(hubCredProvider, nil)
+	m.On("AssumeRole", withCredentials(hubCredProvider), "push", mock.MatchedBy(func(options sts.AssumeRoleOptions) bool {
+		return options.SourceIdentity == "" && options.Tags["team"] == "platform" && options.TransitiveTagKeys[0] == "team"
+	})).Return(pushCredProvider, nil)
+	options := Options{
+		Region:      "us-east-1",
+		AssumeRoles: []string{"hub", "push"},
+		RoleOptions: sts.AssumeRoleOptions{Tags: tags, TransitiveTagKeys: []string{"team"}, SourceIdentity: "jenkins"},
+	}
+
+	_, err := getClientConfig(options, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{Credentials: aws.NewStaticCredentialsProvider("AKID", "SECRET", "")}, nil
+	})
+
+	require.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestEcrClient_GetClientConfig_SkipsRoleAssumedByProfile(t *testing.T) {
+	m := &mockRoleAssumer{}
+	profileCredProvider := &stscreds.AssumeRoleProvider{}
+	pushCredProvider := &sts.CredentialsProvider{}
+	m.On("AssumeRole", withCredentials(profileCredProvider), "push", mock.MatchedBy(func(options sts.AssumeRoleOptions) bool {
+		return options.Duration == 0
+	})).Return(pushCredProvider, nil)
+	options := Options{
+		Region:      "us-east-1",
+		AssumeRoles: []string{"hub", "push"},
+		RoleOptions: sts.AssumeRoleOptions{Duration: 2 * time.Hour},
+	}
+
+	result, err := getClientConfig(options, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Credentials:   profileCredProvider,
+			ConfigSources: []interface{}{external.SharedConfig{RoleARN: "hub"}},
+		}, nil
+	})
+
+	require.NoError(t, err)
+	require.Same(t, pushCredProvider, result.Credentials)
+	m.AssertNumberOfCalls(t, "AssumeRole", 1)
+}
+
+func TestEcrClient_GetClientConfig_AssumesRoleFromProfileRole(t *testing.T) {
+	m := &mockRoleAssumer{}
+	profileCredProvider := &stscreds.AssumeRoleProvider{}
+	pushCredProvider := &sts.CredentialsProvider{}
+	m.On("AssumeRole", withCredentials(profileCredProvider), "push", mock.Anything).Return(pushCredProvider, nil)
+
+	result, err := getClientConfig(Options{Region: "us-east-1", AssumeRoles: []string{"push"}}, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{
+			Credentials:   profileCredProvider,
+			ConfigSources: []interface{}{external.SharedConfig{RoleARN: "hub"}},
+		}, nil
+	})
+
+	require.NoError(t, err)
+	require.Same(t, pushCredProvider, result.Credentials)
+}
+
 func TestEcrClient_GetClientConfig_ReturnsErrorOnBadAssumeRole(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
-	m.On("AssumeRole", mock.Anything, "testing").Return(dummyCredProvider, errors.New("some error"))
+	m.On("AssumeRole", mock.Anything, "testing", mock.Anything).Return(dummyCredProvider, errors.New("some error"))
 
-	_, err := getClientConfig("us-east-1", "testing", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig(Options{Region: "us-east-1", AssumeRoles: []string{"testing"}}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "us-east-1",
 			Credentials: dummyCredProvider,
@@ -78,11 +256,54 @@ func TestEcrClient_GetClientConfig_ReturnsErrorOnBadAssumeRole(t *testing.T) {
 	require.EqualError(t, err, "some error")
 }
 
+func TestEcrClient_GetClientConfig_WebIdentityAssumesRoleWithToken(t *testing.T) {
+	m := &mockRoleAssumer{}
+	webIdentityCredProvider := &sts.CredentialsProvider{}
+	m.On("AssumeRoleWithWebIdentity", mock.Anything, "testing", "/token", mock.Anything).Return(webIdentityCredProvider, nil)
+
+	result, err := getClientConfig(Options{Region: "us-east-1", AssumeRoles: []string{"testing"}, WebIdentityTokenFile: "/token"}, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{}, nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, webIdentityCredProvider, result.Credentials)
+	m.AssertNotCalled(t, "AssumeRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEcrClient_GetClientConfig_WebIdentityFromEnvironmentAssumesRoleAfterwards(t *testing.T) {
+	_ = os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/token")
+	_ = os.Setenv("AWS_ROLE_ARN", "web-identity")
+	defer os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	defer os.Unsetenv("AWS_ROLE_ARN")
+	m := &mockRoleAssumer{}
+	webIdentityCredProvider := &sts.CredentialsProvider{}
+	assumedCredProvider := &sts.CredentialsProvider{}
+	m.On("AssumeRoleWithWebIdentity", mock.Anything, "web-identity", "/token", mock.Anything).Return(webIdentityCredProvider, nil)
+	m.On("AssumeRole", mock.Anything, "testing", mock.Anything).Return(assumedCredProvider, nil)
+
+	result, err := getClientConfig(Options{Region: "us-east-1", AssumeRoles: []string{"testing"}}, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{}, nil
+	})
+
+	require.NoError(t, err)
+	require.Same(t, assumedCredProvider, result.Credentials)
+}
+
+func TestEcrClient_GetClientConfig_WebIdentityRequiresRole(t *testing.T) {
+	m := &mockRoleAssumer{}
+
+	_, err := getClientConfig(Options{Region: "us-east-1", WebIdentityTokenFile: "/token"}, m, func(configs ...external.Config) (aws.Config, error) {
+		return aws.Config{}, nil
+	})
+
+	require.Equal(t, ErrNoWebIdentityRole, err)
+}
+
 func TestEcrClient_GetClientConfig_RegionFlagUpdatesConfigRegion(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
 
-	result, err := getClientConfig("us-east-2", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	result, err := getClientConfig(Options{Region: "us-east-2"}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "us-east-1",
 			Credentials: dummyCredProvider,
@@ -96,7 +317,7 @@ func TestEcrClient_GetClientConfig_RegionFlagUpdatesConfigRegion(t *testing.T) {
 func TestEcrClient_GetClientConfig_ReturnsErrOnBadConfigLoad(t *testing.T) {
 	m := &mockRoleAssumer{}
 
-	_, err := getClientConfig("us-east-1", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig(Options{Region: "us-east-1"}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{}, errors.New("some error")
 	})
 
@@ -106,7 +327,7 @@ func TestEcrClient_GetClientConfig_ReturnsErrOnBadConfigLoad(t *testing.T) {
 func TestEcrClient_GetClientConfig_ReturnsErrNoCredentials(t *testing.T) {
 	m := &mockRoleAssumer{}
 
-	_, err := getClientConfig("us-east-1", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig(Options{Region: "us-east-1"}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Credentials: nil,
 		}, nil
@@ -119,7 +340,7 @@ func TestEcrClient_GetClientConfig_ReturnsErrorOnBadService(t *testing.T) {
 	m := &mockRoleAssumer{}
 	dummyCredProvider := &sts.CredentialsProvider{}
 
-	_, err := getClientConfig("", "", "", m, func(configs ...external.Config) (aws.Config, error) {
+	_, err := getClientConfig(Options{}, m, func(configs ...external.Config) (aws.Config, error) {
 		return aws.Config{
 			Region:      "macho-man-randy-savage",
 			Credentials: dummyCredProvider,
@@ -135,7 +356,7 @@ func TestEcrClient_GetClientConfig_ValidProfile(t *testing.T) {
 	defer os.Unsetenv("AWS_CONFIG_FILE")
 	m := &mockRoleAssumer{}
 
-	result, err := getClientConfig("us-east-1", "", "my-profile", m, external.LoadDefaultAWSConfig)
+	result, err := getClientConfig(Options{Region: "us-east-1", Profile: "my-profile"}, m, external.LoadDefaultAWSConfig)
 
 	sharedConfigSource := false
 	for _, source := range result.ConfigSources {
@@ -152,7 +373,7 @@ func TestEcrClient_GetClientConfig_ValidProfile(t *testing.T) {
 func TestEcrClient_GetClientConfig_BadProfile(t *testing.T) {
 	m := &mockRoleAssumer{}
 
-	result, err := getClientConfig("us-east-1", "", "not-a-profile", m, external.LoadDefaultAWSConfig)
+	result, err := getClientConfig(Options{Region: "us-east-1", Profile: "not-a-profile"}, m, external.LoadDefaultAWSConfig)
 
 	sharedConfigSource := false
 	for _, source := range result.ConfigSources {
@@ -166,14 +387,72 @@ func TestEcrClient_GetClientConfig_BadProfile(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestEcrClient_GetClientConfig_CredentialProcessProfile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the credential process of the test is a shell command")
+	}
+	dir, err := ioutil.TempDir("", "process")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "credentials.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo '{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "secret"}'
+`), 0700))
+	path := createProfile("tmp-profile", "[profile process]\ncredential_process = "+script)
+	defer os.Remove(path)
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+
+	result, err := getClientConfig(Options{Region: "us-east-1", Profile: "process"}, &mockRoleAssumer{}, withSSO(external.LoadDefaultAWSConfig))
+	require.NoError(t, err)
+	credentials, err := result.Credentials.Retrieve(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, "AKIDPROCESS", credentials.AccessKeyID)
+}
+
+func TestEcrClient_GetClientConfig_SSOProfile(t *testing.T) {
+	path := createProfile("tmp-profile", `[profile dev-sso]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 112233445566
+sso_role_name = Developer`)
+	defer os.Remove(path)
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+	home, err := ioutil.TempDir("", "home")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	_ = os.Setenv("HOME", home)
+	cacheDir := filepath.Join(home, ".aws", "sso", "cache")
+	require.NoError(t, os.MkdirAll(cacheDir, 0700))
+	tokenFile := filepath.Join(cacheDir, fmt.Sprintf("%x.json", sha1.Sum([]byte("https://example.awsapps.com/start"))))
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte(`{"accessToken": "sso-token", "expiresAt": "2099-01-01T00:00:00Z"}`), 0600))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"roleCredentials": {"accessKeyId": "ASIASSO", "secretAccessKey": "secret", "sessionToken": "token", "expiration": 4102444800000}}`))
+	}))
+	defer server.Close()
+
+	result, err := getClientConfig(Options{Region: "us-east-1", Profile: "dev-sso"}, &mockRoleAssumer{}, withSSO(func(configs ...external.Config) (aws.Config, error) {
+		cfg, err := external.LoadDefaultAWSConfig(configs...)
+		cfg.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+		return cfg, err
+	}))
+	require.NoError(t, err)
+	credentials, err := result.Credentials.Retrieve(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, "ASIASSO", credentials.AccessKeyID)
+}
+
 func TestEcrClient_NewClient_ReturnsValidClient(t *testing.T) {
-	_, err := NewClient("us-east-1", "", "")
+	_, err := NewClient(Options{Region: "us-east-1"})
 
 	assert.NoError(t, err)
 }
 
 func TestEcrClient_NewClient_ReturnsErrorForBadConfig(t *testing.T) {
-	_, err := NewClient("macho-man-randy-savage", "", "")
+	_, err := NewClient(Options{Region: "macho-man-randy-savage"})
 
 	require.Error(t, err)
 }
@@ -255,6 +534,409 @@ func TestEcrClient_SetupRepository_ReturnsErrorOnGetRepositoryURIError(t *testin
 	require.Empty(t, result)
 }
 
+func TestEcrClient_LayerExists_ReturnsTrueForAvailableLayer(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, "BatchCheckLayerAvailability", operation)
+		return http.StatusOK, map[string]interface{}{
+			"layers": []map[string]interface{}{
+				{"layerDigest": "sha256:abcd", "layerAvailability": "AVAILABLE"},
+			},
+		}
+	})
+	defer server.Close()
+
+	result, err := c.LayerExists("myrepository", "sha256:abcd")
+
+	require.NoError(t, err)
+	require.True(t, result)
+}
+
+func TestEcrClient_LayerExists_ReturnsFalseForMissingLayer(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		return http.StatusOK, map[string]interface{}{
+			"failures": []map[string]interface{}{
+				{"layerDigest": "sha256:abcd", "failureCode": "MissingLayerDigest"},
+			},
+		}
+	})
+	defer server.Close()
+
+	result, err := c.LayerExists("myrepository", "sha256:abcd")
+
+	require.NoError(t, err)
+	require.False(t, result)
+}
+
+func TestEcrClient_UploadLayer_UploadsInParts(t *testing.T) {
+	var parts []string
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		switch operation {
+		case "InitiateLayerUpload":
+			return http.StatusOK, map[string]interface{}{"uploadId": "upload", "partSize": 4}
+		case "UploadLayerPart":
+			blob, _ := base64.StdEncoding.DecodeString(input["layerPartBlob"].(string))
+			parts = append(parts, fmt.Sprintf("%v-%v:%s", input["partFirstByte"], input["partLastByte"], blob))
+			return http.StatusOK, map[string]interface{}{"uploadId": "upload"}
+		case "CompleteLayerUpload":
+			require.Equal(t, []interface{}{"sha256:abcd"}, input["layerDigests"])
+			return http.StatusOK, map[string]interface{}{"layerDigest": "sha256:abcd"}
+		}
+		return http.StatusBadRequest, nil
+	})
+	defer server.Close()
+
+	err := c.UploadLayer("myrepository", "sha256:abcd", strings.NewReader("0123456789"))
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"0-3:0123", "4-7:4567", "8-9:89"}, parts)
+}
+
+func TestEcrClient_UploadLayer_IgnoresLayerAlreadyExists(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		switch operation {
+		case "InitiateLayerUpload":
+			return http.StatusOK, map[string]interface{}{"uploadId": "upload", "partSize": 1024}
+		case "CompleteLayerUpload":
+			return http.StatusBadRequest, map[string]interface{}{"__type": "LayerAlreadyExistsException"}
+		}
+		return http.StatusOK, map[string]interface{}{}
+	})
+	defer server.Close()
+
+	err := c.UploadLayer("myrepository", "sha256:abcd", strings.NewReader("layer"))
+
+	require.NoError(t, err)
+}
+
+func TestEcrClient_PutImage_ReturnsDigest(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, "PutImage", operation)
+		require.Equal(t, "1.2.3", input["imageTag"])
+		return http.StatusOK, map[string]interface{}{
+			"image": map[string]interface{}{
+				"imageId": map[string]interface{}{"imageDigest": "sha256:abcd", "imageTag": "1.2.3"},
+			},
+		}
+	})
+	defer server.Close()
+
+	result, err := c.PutImage("myrepository", "1.2.3", "{}", "")
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abcd", result)
+}
+
+func TestEcrClient_PutImage_ReturnsManifestDigestWhenImageAlreadyExists(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		return http.StatusBadRequest, map[string]interface{}{"__type": "ImageAlreadyExistsException"}
+	})
+	defer server.Close()
+
+	result, err := c.PutImage("myrepository", "1.2.3", "{}", "")
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", result)
+}
+
+func TestEcrClient_GetImageManifest_ReturnsManifestByTag(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, "BatchGetImage", operation)
+		require.Equal(t, []interface{}{map[string]interface{}{"imageTag": "1.2.3"}}, input["imageIds"])
+		return http.StatusOK, map[string]interface{}{
+			"images": []map[string]interface{}{{
+				"imageId":                map[string]interface{}{"imageDigest": "sha256:abcd", "imageTag": "1.2.3"},
+				"imageManifest":          "{}",
+				"imageManifestMediaType": "application/vnd.oci.image.manifest.v1+json",
+			}},
+		}
+	})
+	defer server.Close()
+
+	result, err := c.GetImageManifest("myrepository", "1.2.3")
+
+	require.NoError(t, err)
+	require.Equal(t, &ImageManifest{
+		Digest:    "sha256:abcd",
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Manifest:  "{}",
+	}, result)
+}
+
+func TestEcrClient_GetImageManifest_UsesDigestReference(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, []interface{}{map[string]interface{}{"imageDigest": "sha256:abcd"}}, input["imageIds"])
+		return http.StatusOK, map[string]interface{}{
+			"images": []map[string]interface{}{{"imageManifest": "{}"}},
+		}
+	})
+	defer server.Close()
+
+	result, err := c.GetImageManifest("myrepository", "sha256:abcd")
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", result.Digest)
+}
+
+func TestEcrClient_GetImageManifest_ReturnsErrImageNotFound(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		return http.StatusOK, map[string]interface{}{
+			"failures": []map[string]interface{}{{"failureCode": "ImageNotFound"}},
+		}
+	})
+	defer server.Close()
+
+	_, err := c.GetImageManifest("myrepository", "1.2.3")
+
+	require.Equal(t, ErrImageNotFound, err)
+}
+
+func TestEcrClient_GetLayerURL_ReturnsDownloadURL(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, "GetDownloadUrlForLayer", operation)
+		require.Equal(t, "sha256:abcd", input["layerDigest"])
+		return http.StatusOK, map[string]interface{}{"downloadUrl": "https://example.com/layer", "layerDigest": "sha256:abcd"}
+	})
+	defer server.Close()
+
+	result, err := c.GetLayerURL("myrepository", "sha256:abcd")
+
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/layer", result)
+}
+
+func TestEcrClient_ListImageTags_ReturnsTagsFromEveryPage(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, "ListImages", operation)
+		require.Equal(t, map[string]interface{}{"tagStatus": "TAGGED"}, input["filter"])
+		if input["nextToken"] == nil {
+			return http.StatusOK, map[string]interface{}{
+				"imageIds":  []map[string]interface{}{{"imageTag": "1.0.0"}, {"imageTag": "1.1.0"}},
+				"nextToken": "page2",
+			}
+		}
+		return http.StatusOK, map[string]interface{}{
+			"imageIds": []map[string]interface{}{{"imageTag": "latest"}},
+		}
+	})
+	defer server.Close()
+
+	result, err := c.ListImageTags("myrepository")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.0.0", "1.1.0", "latest"}, result)
+}
+
+func TestEcrClient_RegistryID_IsSetOnRequests(t *testing.T) {
+	var registryIDs []interface{}
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		switch operation {
+		case "GetAuthorizationToken":
+			registryIDs = append(registryIDs, input["registryIds"])
+			return http.StatusOK, map[string]interface{}{
+				"authorizationData": []map[string]interface{}{
+					{"authorizationToken": "QVdTOmVjcnJlZ2lzdHJ5Y3JlZGVudGlhbHM=", "proxyEndpoint": "https://endpoint"},
+				},
+			}
+		case "DescribeRepositories":
+			registryIDs = append(registryIDs, input["registryId"])
+			return http.StatusOK, map[string]interface{}{
+				"repositories": []map[string]interface{}{{"repositoryUri": "uri"}},
+			}
+		}
+		return http.StatusBadRequest, nil
+	})
+	defer server.Close()
+	c.registry = "112233445566"
+
+	_, err := c.GetAuthorizationToken()
+	require.NoError(t, err)
+	_, err = c.GetRepositoryURI("myrepository")
+	require.NoError(t, err)
+
+	require.Equal(t, []interface{}{[]interface{}{"112233445566"}, "112233445566"}, registryIDs)
+}
+
+func TestEcrClient_GetAuthorizationToken_UsesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecr")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	calls := 0
+	expiresAt := time.Now().Add(12 * time.Hour).Truncate(time.Second)
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		calls++
+		return http.StatusOK, map[string]interface{}{
+			"authorizationData": []map[string]interface{}{{
+				"authorizationToken": "QVdTOmVjcnJlZ2lzdHJ5Y3JlZGVudGlhbHM=",
+				"proxyEndpoint":      "https://endpoint",
+				"expiresAt":          expiresAt.Unix(),
+			}},
+		}
+	})
+	defer server.Close()
+	c.cache = cache.New(dir)
+
+	first, err := c.GetAuthorizationToken()
+	require.NoError(t, err)
+	second, err := c.GetAuthorizationToken()
+	require.NoError(t, err)
+
+	require.Equal(t, 1, calls)
+	require.Equal(t, "ecrregistrycredentials", second.Password)
+	require.True(t, expiresAt.Equal(first.ExpiresAt))
+	require.True(t, expiresAt.Equal(second.ExpiresAt))
+}
+
+func TestEcrClient_RegistryID_IsOmittedWhenEmpty(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		_, ok := input["registryId"]
+		require.False(t, ok)
+		return http.StatusOK, map[string]interface{}{"layers": []map[string]interface{}{}}
+	})
+	defer server.Close()
+
+	_, err := c.LayerExists("myrepository", "sha256:abcd")
+
+	require.NoError(t, err)
+}
+
+func TestEcrClient_CreateRepository_ReturnsErrForeignRegistry(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		return http.StatusOK, map[string]interface{}{}
+	})
+	defer server.Close()
+	c.registry = "112233445566"
+	c.callerAccount = func() (string, error) {
+		return "665544332211", nil
+	}
+
+	err := c.CreateRepository("myrepository")
+
+	require.Equal(t, ErrForeignRegistry, err)
+}
+
+func TestEcrClient_CreateRepository_CreatesInOwnRegistry(t *testing.T) {
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		require.Equal(t, "CreateRepository", operation)
+		return http.StatusOK, map[string]interface{}{}
+	})
+	defer server.Close()
+	c.registry = "112233445566"
+	c.callerAccount = func() (string, error) {
+		return "112233445566", nil
+	}
+
+	err := c.CreateRepository("myrepository")
+
+	require.NoError(t, err)
+}
+
+// newFakeECRClient returns a client that sends its requests to a local server, which passes the operation name and
+// decoded input of each request to 'handler' and encodes whatever it returns as the response
+func newFakeECRClient(t *testing.T, handler func(operation string, input map[string]interface{}) (int, interface{})) (*ecrClient, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerRegistry_V20150921.")
+		status, output := handler(operation, input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Header().Set("X-Amzn-RequestId", "request-id")
+		w.WriteHeader(status)
+		require.NoError(t, json.NewEncoder(w).Encode(output))
+	}))
+
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+	config.Retryer = aws.NoOpRetryer{}
+
+	return &ecrClient{
+		Client: ecr.New(config),
+		log:    log.WithField("component", "ecr"),
+	}, server
+}
+
+func TestEcrClient_LogRequest_LogsOperationAndRequestID(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	c, server := newFakeECRClient(t, func(operation string, input map[string]interface{}) (int, interface{}) {
+		return http.StatusOK, map[string]interface{}{}
+	})
+	defer server.Close()
+	c.Handlers.Complete.PushBack(logging.RequestLogger(logger.WithField("component", "ecr")))
+
+	_, err := c.RepositoryExists("myrepository")
+
+	require.NoError(t, err)
+	require.Equal(t, log.InfoLevel, hook.LastEntry().Level)
+	require.Equal(t, "DescribeRepositories", hook.LastEntry().Data["operation"])
+	require.Equal(t, "request-id", hook.LastEntry().Data["requestId"])
+}
+
+func TestEcrClient_OutdatedTags_ReturnsMissingAndDifferentTags(t *testing.T) {
+	m := mockECRClient{}
+	m.On("GetImageManifest", "myrepository", "missing").Return(nil, ErrImageNotFound)
+	m.On("GetImageManifest", "myrepository", "same").Return(&ImageManifest{Digest: "sha256:same"}, nil)
+	m.On("GetImageManifest", "myrepository", "different").Return(&ImageManifest{Digest: "sha256:different"}, nil)
+
+	result, err := OutdatedTags(&m, "myrepository", []string{"missing", "same", "different"}, func(remote *ImageManifest) (bool, error) {
+		return remote.Digest == "sha256:same", nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"missing", "different"}, result)
+}
+
+func TestEcrClient_OutdatedTags_ReturnsErrorOnGetImageManifestError(t *testing.T) {
+	m := mockECRClient{}
+	m.On("GetImageManifest", mock.Anything, mock.Anything).Return(nil, errors.New("error"))
+
+	_, err := OutdatedTags(&m, "myrepository", []string{"1.0"}, func(remote *ImageManifest) (bool, error) {
+		return true, nil
+	})
+
+	require.EqualError(t, err, "error")
+}
+
+func TestEcrClient_VerifyDigest_ReturnsDigestWhenEveryTagMatches(t *testing.T) {
+	m := mockECRClient{}
+	m.On("GetImageManifest", "myrepository", mock.Anything).Return(&ImageManifest{Digest: "sha256:digest"}, nil)
+
+	result, err := VerifyDigest(&m, "myrepository", []string{"1.0", "latest"}, "sha256:digest")
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:digest", result)
+	m.AssertNumberOfCalls(t, "GetImageManifest", 2)
+}
+
+func TestEcrClient_VerifyDigest_UsesFirstTagWhenDigestIsEmpty(t *testing.T) {
+	m := mockECRClient{}
+	m.On("GetImageManifest", "myrepository", "1.0").Return(&ImageManifest{Digest: "sha256:digest"}, nil)
+
+	result, err := VerifyDigest(&m, "myrepository", []string{"1.0"}, "")
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:digest", result)
+}
+
+func TestEcrClient_VerifyDigest_ReturnsErrTagDigestMismatch(t *testing.T) {
+	m := mockECRClient{}
+	m.On("GetImageManifest", "myrepository", "1.0").Return(&ImageManifest{Digest: "sha256:digest"}, nil)
+	m.On("GetImageManifest", "myrepository", "latest").Return(&ImageManifest{Digest: "sha256:other"}, nil)
+
+	_, err := VerifyDigest(&m, "myrepository", []string{"1.0", "latest"}, "sha256:digest")
+
+	require.Equal(t, ErrTagDigestMismatch, err)
+}
+
+func TestEcrClient_ImageManifest_ConfigDigest(t *testing.T) {
+	result, err := ImageManifest{Manifest: `{"schemaVersion":2,"config":{"digest":"sha256:config"}}`}.ConfigDigest()
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:config", result)
+}
+
 func createProfile(localpath string, profile string) string {
 	pwd, err := os.Getwd()
 	if err != nil {
@@ -0,0 +1,47 @@
+package ecr
+
+import (
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// registryHostRegexp matches ECR registry hosts such as '112233445566.dkr.ecr.us-east-1.amazonaws.com', including
+// FIPS endpoints and the China partition
+var registryHostRegexp = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ParseRegistryHost extracts the registry ID (AWS account ID) and region from an ECR registry host name. It returns
+// false when the host is not an ECR registry.
+func ParseRegistryHost(host string) (registryID string, region string, ok bool) {
+	matches := registryHostRegexp.FindStringSubmatch(host)
+	if matches == nil {
+		return "", "", false
+	}
+
+	return matches[1], matches[2], true
+}
+
+// UseRegistryHost makes the options refer to the ECR registry 'host', such as the registry of a full ECR URI, by using
+// its region and registry ID. An explicitly set registry ID must match the registry, whereas a different region is
+// replaced with a warning. The options are unchanged when 'host' is not an ECR registry.
+func (o *Options) UseRegistryHost(host string) error {
+	registryID, region, ok := ParseRegistryHost(host)
+	if !ok {
+		return nil
+	}
+
+	if o.RegistryID != "" && o.RegistryID != registryID {
+		return ErrRegistryIDMismatch
+	}
+
+	if o.Region != "" && o.Region != region {
+		log.WithFields(log.Fields{
+			"flag":     o.Region,
+			"registry": region,
+		}).Warn("Region does not match the region of the ECR registry; using the registry's region")
+	}
+
+	o.Region = region
+	o.RegistryID = registryID
+	return nil
+}
@@ -0,0 +1,72 @@
+package ecr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEcrRegistry_ParseRegistryHost(t *testing.T) {
+	tests := []struct {
+		host       string
+		registryID string
+		region     string
+		ok         bool
+	}{
+		{"112233445566.dkr.ecr.us-east-1.amazonaws.com", "112233445566", "us-east-1", true},
+		{"112233445566.dkr.ecr.eu-west-1.amazonaws.com", "112233445566", "eu-west-1", true},
+		{"112233445566.dkr.ecr-fips.us-gov-west-1.amazonaws.com", "112233445566", "us-gov-west-1", true},
+		{"112233445566.dkr.ecr.cn-north-1.amazonaws.com.cn", "112233445566", "cn-north-1", true},
+		{"localhost:5000", "", "", false},
+		{"registry.example.com", "", "", false},
+		{"1122334455.dkr.ecr.us-east-1.amazonaws.com", "", "", false},
+		{"112233445566.dkr.ecr.us-east-1.amazonaws.com.evil.com", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			registryID, region, ok := ParseRegistryHost(test.host)
+
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.registryID, registryID)
+			require.Equal(t, test.region, region)
+		})
+	}
+}
+
+func TestEcrRegistry_UseRegistryHost_UsesRegionAndRegistryIDOfHost(t *testing.T) {
+	options := Options{Region: "us-east-1"}
+
+	err := options.UseRegistryHost("112233445566.dkr.ecr.eu-west-1.amazonaws.com")
+
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", options.Region)
+	require.Equal(t, "112233445566", options.RegistryID)
+}
+
+func TestEcrRegistry_UseRegistryHost_AcceptsMatchingRegistryID(t *testing.T) {
+	options := Options{RegistryID: "112233445566"}
+
+	err := options.UseRegistryHost("112233445566.dkr.ecr.eu-west-1.amazonaws.com")
+
+	require.NoError(t, err)
+	require.Equal(t, "112233445566", options.RegistryID)
+}
+
+func TestEcrRegistry_UseRegistryHost_RejectsOtherRegistryID(t *testing.T) {
+	options := Options{RegistryID: "998877665544"}
+
+	err := options.UseRegistryHost("112233445566.dkr.ecr.eu-west-1.amazonaws.com")
+
+	require.Equal(t, ErrRegistryIDMismatch, err)
+}
+
+func TestEcrRegistry_UseRegistryHost_KeepsOptionsForOtherHosts(t *testing.T) {
+	options := Options{Region: "us-east-1", RegistryID: "998877665544"}
+
+	err := options.UseRegistryHost("")
+
+	require.NoError(t, err)
+	require.Equal(t, Options{Region: "us-east-1", RegistryID: "998877665544"}, options)
+}
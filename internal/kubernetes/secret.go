@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/hylandsoftware/trebuchet/internal/dockerconfig"
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+)
+
+const (
+	// SecretTypeDockerConfigJSON is the type of secrets that Kubernetes accepts as imagePullSecrets
+	SecretTypeDockerConfigJSON = "kubernetes.io/dockerconfigjson"
+
+	dockerConfigJSONKey = ".dockerconfigjson"
+)
+
+// Secret is a Kubernetes Secret manifest
+type Secret struct {
+	APIVersion string            `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string            `json:"kind" yaml:"kind"`
+	Metadata   ObjectMeta        `json:"metadata" yaml:"metadata"`
+	Type       string            `json:"type" yaml:"type"`
+	Data       map[string]string `json:"data" yaml:"data"`
+}
+
+// ObjectMeta is the metadata of a Kubernetes object
+type ObjectMeta struct {
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+type dockerConfigAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// NewDockerConfigSecret returns an image pull secret with the credentials of the ECR registry in 'auth'
+func NewDockerConfigSecret(name string, namespace string, auth ecr.RegistryAuth) (*Secret, error) {
+	config, err := json.Marshal(map[string]map[string]dockerConfigAuth{
+		"auths": {
+			dockerconfig.HostName(auth.ProxyEndpoint): {
+				Username: auth.Username,
+				Password: auth.Password,
+				Auth:     base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: SecretTypeDockerConfigJSON,
+		Data: map[string]string{
+			dockerConfigJSONKey: base64.StdEncoding.EncodeToString(config),
+		},
+	}, nil
+}
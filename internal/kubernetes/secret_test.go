@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestKubernetes_NewDockerConfigSecret_ContainsRegistryCredentials(t *testing.T) {
+	auth := ecr.RegistryAuth{
+		ProxyEndpoint: "https://112233445566.dkr.ecr.us-east-1.amazonaws.com",
+		Username:      "AWS",
+		Password:      "token",
+	}
+
+	result, err := NewDockerConfigSecret("ecr", "app", auth)
+
+	require.NoError(t, err)
+	require.Equal(t, "ecr", result.Metadata.Name)
+	require.Equal(t, "app", result.Metadata.Namespace)
+	require.Equal(t, SecretTypeDockerConfigJSON, result.Type)
+
+	config, err := base64.StdEncoding.DecodeString(result.Data[".dockerconfigjson"])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"auths": {"112233445566.dkr.ecr.us-east-1.amazonaws.com": {
+		"username": "AWS",
+		"password": "token",
+		"auth": "QVdTOnRva2Vu"
+	}}}`, string(config))
+}
+
+func TestKubernetes_Secret_MarshalsAsManifest(t *testing.T) {
+	secret := Secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   ObjectMeta{Name: "ecr"},
+		Type:       SecretTypeDockerConfigJSON,
+		Data:       map[string]string{".dockerconfigjson": "e30="},
+	}
+
+	result, err := yaml.Marshal(secret)
+
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: ecr
+type: kubernetes.io/dockerconfigjson
+data:
+  .dockerconfigjson: e30=
+`, string(result))
+}
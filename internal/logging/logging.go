@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var ErrUnknownFormat = errors.New("log format must be one of text or json")
+
+// NewFormatter returns the logrus formatter for the given log format. Colors are only used by the text format.
+func NewFormatter(format string, colors bool) (log.Formatter, error) {
+	switch format {
+	case FormatText, "":
+		return &log.TextFormatter{ForceColors: colors, DisableColors: !colors}, nil
+	case FormatJSON:
+		return &log.JSONFormatter{}, nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// FileHook is a logrus hook that writes every log entry to a file in addition to the output of the logger
+type FileHook struct {
+	writer    io.Writer
+	formatter log.Formatter
+}
+
+// NewFileHook creates a hook that writes log entries to 'writer' in the given log format, without colors
+func NewFileHook(writer io.Writer, format string) (*FileHook, error) {
+	formatter, err := NewFormatter(format, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileHook{
+		writer:    writer,
+		formatter: formatter,
+	}, nil
+}
+
+// Levels returns every log level, as the level of the logger already decides which entries are logged
+func (h *FileHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire writes the log entry to the file
+func (h *FileHook) Fire(entry *log.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.writer.Write(line)
+	return err
+}
+
+// RequestLogger returns an AWS SDK request handler that logs the operation and request ID of every completed request,
+// so that requests can be traced in CloudTrail and support cases
+func RequestLogger(entry *log.Entry) func(*aws.Request) {
+	return func(r *aws.Request) {
+		fields := log.Fields{
+			"operation": r.Operation.Name,
+			"requestId": r.RequestID,
+		}
+		if r.Error != nil {
+			fields["error"] = r.Error
+		}
+
+		entry.WithFields(fields).Info("Completed AWS request")
+	}
+}
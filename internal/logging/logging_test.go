@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging_NewFormatter_JSON(t *testing.T) {
+	result, err := NewFormatter(FormatJSON, true)
+
+	require.NoError(t, err)
+	require.IsType(t, &log.JSONFormatter{}, result)
+}
+
+func TestLogging_NewFormatter_TextWithoutColors(t *testing.T) {
+	result, err := NewFormatter(FormatText, false)
+
+	require.NoError(t, err)
+	require.True(t, result.(*log.TextFormatter).DisableColors)
+}
+
+func TestLogging_NewFormatter_RejectsUnknownFormat(t *testing.T) {
+	_, err := NewFormatter("xml", false)
+
+	require.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestLogging_FileHook_WritesEntriesInFormat(t *testing.T) {
+	var file bytes.Buffer
+	hook, err := NewFileHook(&file, FormatJSON)
+	require.NoError(t, err)
+
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.AddHook(hook)
+
+	logger.WithField("component", "ecr").WithField("repository", "app").Info("Repository exists")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(file.Bytes(), &entry))
+	require.Equal(t, "ecr", entry["component"])
+	require.Equal(t, "app", entry["repository"])
+	require.Equal(t, "Repository exists", entry["msg"])
+	require.Equal(t, "info", entry["level"])
+}
@@ -0,0 +1,103 @@
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var ErrUnknownFormat = errors.New("output must be one of text, json or yaml")
+
+// Result describes the outcome of a command for consumption by scripts and pipelines
+type Result struct {
+	RepositoryURI string   `json:"repositoryUri" yaml:"repositoryUri"`
+	Tags          []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Digest        string   `json:"digest,omitempty" yaml:"digest,omitempty"`
+	Region        string   `json:"region" yaml:"region"`
+	RegistryID    string   `json:"registryId" yaml:"registryId"`
+	Duration      string   `json:"duration" yaml:"duration"`
+}
+
+// SetDuration records the time elapsed since 'start' in the result
+func (r *Result) SetDuration(start time.Time) {
+	r.Duration = time.Since(start).Round(time.Millisecond).String()
+}
+
+// Printer writes results in the output format selected by the user
+type Printer struct {
+	// Format is one of FormatText, FormatJSON or FormatYAML
+	Format string
+
+	// Template is a Go template that is executed against the result instead of using Format, when set
+	Template string
+}
+
+// Validate returns an error if the printer's format or template is invalid
+func (p Printer) Validate() error {
+	switch p.Format {
+	case "", FormatText, FormatJSON, FormatYAML:
+	default:
+		return ErrUnknownFormat
+	}
+
+	if p.Template != "" {
+		if _, err := template.New("format").Parse(p.Template); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Print writes the result, which is usually a Result, to 'w'. In text format 'text' is written instead of the result,
+// unless it is empty.
+func (p Printer) Print(w io.Writer, result interface{}, text string) error {
+	if p.Template != "" {
+		tmpl, err := template.New("format").Parse(p.Template)
+		if err != nil {
+			return err
+		}
+
+		if err := tmpl.Execute(w, result); err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w)
+		return err
+	}
+
+	switch p.Format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case FormatYAML:
+		content, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(content)
+		return err
+	case "", FormatText:
+		if text == "" {
+			return nil
+		}
+
+		_, err := fmt.Fprintln(w, text)
+		return err
+	}
+
+	return ErrUnknownFormat
+}
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testResult = Result{
+	RepositoryURI: "112233445566.dkr.ecr.us-east-1.amazonaws.com/app",
+	Tags:          []string{"1.0", "latest"},
+	Digest:        "sha256:digest",
+	Region:        "us-east-1",
+	RegistryID:    "112233445566",
+	Duration:      "1.5s",
+}
+
+func TestOutput_Print_Text(t *testing.T) {
+	var out bytes.Buffer
+
+	err := Printer{Format: FormatText}.Print(&out, testResult, "sha256:digest")
+
+	require.NoError(t, err)
+	require.Equal(t, "sha256:digest\n", out.String())
+}
+
+func TestOutput_Print_TextWithoutTextPrintsNothing(t *testing.T) {
+	var out bytes.Buffer
+
+	err := Printer{}.Print(&out, testResult, "")
+
+	require.NoError(t, err)
+	require.Empty(t, out.String())
+}
+
+func TestOutput_Print_JSON(t *testing.T) {
+	var out bytes.Buffer
+
+	err := Printer{Format: FormatJSON}.Print(&out, testResult, "")
+
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"repositoryUri": "112233445566.dkr.ecr.us-east-1.amazonaws.com/app",
+		"tags": ["1.0", "latest"],
+		"digest": "sha256:digest",
+		"region": "us-east-1",
+		"registryId": "112233445566",
+		"duration": "1.5s"
+	}`, out.String())
+}
+
+func TestOutput_Print_YAML(t *testing.T) {
+	var out bytes.Buffer
+
+	err := Printer{Format: FormatYAML}.Print(&out, testResult, "")
+
+	require.NoError(t, err)
+	require.Equal(t, `repositoryUri: 112233445566.dkr.ecr.us-east-1.amazonaws.com/app
+tags:
+- "1.0"
+- latest
+digest: sha256:digest
+region: us-east-1
+registryId: "112233445566"
+duration: 1.5s
+`, out.String())
+}
+
+func TestOutput_Print_TemplateTakesPrecedence(t *testing.T) {
+	var out bytes.Buffer
+
+	err := Printer{Format: FormatJSON, Template: "{{.RepositoryURI}}@{{.Digest}}"}.Print(&out, testResult, "")
+
+	require.NoError(t, err)
+	require.Equal(t, "112233445566.dkr.ecr.us-east-1.amazonaws.com/app@sha256:digest\n", out.String())
+}
+
+func TestOutput_Print_OtherResultTypes(t *testing.T) {
+	var out bytes.Buffer
+	result := struct {
+		Username string `json:"username"`
+	}{Username: "AWS"}
+
+	err := Printer{Format: FormatJSON}.Print(&out, result, "")
+
+	require.NoError(t, err)
+	require.JSONEq(t, `{"username": "AWS"}`, out.String())
+}
+
+func TestOutput_Validate_RejectsUnknownFormat(t *testing.T) {
+	err := Printer{Format: "xml"}.Validate()
+
+	require.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestOutput_Validate_RejectsInvalidTemplate(t *testing.T) {
+	err := Printer{Template: "{{.Digest"}.Validate()
+
+	require.Error(t, err)
+}
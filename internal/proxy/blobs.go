@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	digestAlgorithm = "sha256"
+	lockFileName    = "lock"
+
+	// staleDownloadAge is the age after which downloads left behind by processes that did not stop cleanly are removed
+	staleDownloadAge = 24 * time.Hour
+)
+
+var ErrDigestMismatch = errors.New("content does not match its digest")
+
+// BlobCache stores registry blobs in files named after their digest, so that layers pulled repeatedly are downloaded
+// from ECR only once. When the blobs exceed the maximum size, the least recently used blobs are removed. Only sha256
+// digests are cached, and content is verified against its digest before it is stored.
+//
+// Several processes can share the directory of a cache. The blobs in the directory are the state of the cache, which
+// is only changed while holding a lock on the directory, and each process downloads blobs to its own temporary
+// directory.
+type BlobCache struct {
+	dir     string
+	temp    string
+	maxSize int64
+	log     *log.Entry
+	now     func() time.Time
+
+	// createTemp creates the temporary files blobs are downloaded to
+	createTemp func(dir, pattern string) (*os.File, error)
+
+	// mutex serializes the changes of this process, the lock file serializes them with other processes
+	mutex sync.Mutex
+}
+
+// NewBlobCache creates a cache of at most 'maxSize' bytes in 'dir'. Blobs already in the directory are kept, and
+// their modification time determines the order of their eviction. The cache must be closed to remove its temporary
+// directory.
+func NewBlobCache(dir string, maxSize int64) (*BlobCache, error) {
+	for _, name := range []string{digestAlgorithm, "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	temp, err := ioutil.TempDir(filepath.Join(dir, "tmp"), "downloads")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &BlobCache{
+		dir:        dir,
+		temp:       temp,
+		maxSize:    maxSize,
+		log:        log.WithField("component", "blobcache"),
+		now:        time.Now,
+		createTemp: ioutil.TempFile,
+	}
+	c.removeStaleDownloads()
+
+	unlock, err := c.lock()
+	if err != nil {
+		_ = os.RemoveAll(temp)
+		return nil, err
+	}
+	defer unlock()
+
+	blobs, size := c.evict()
+	c.log.WithFields(log.Fields{
+		"dir":   dir,
+		"blobs": blobs,
+		"size":  size,
+	}).Debug("Loaded blob cache")
+
+	return c, nil
+}
+
+// Close removes the temporary directory of the cache. Blobs that are being written can no longer be committed.
+func (c *BlobCache) Close() error {
+	return os.RemoveAll(c.temp)
+}
+
+// Open returns the cached blob with the digest, or false if it is not cached
+func (c *BlobCache) Open(digest string) (*os.File, bool) {
+	if !validDigest(digest) {
+		return nil, false
+	}
+
+	path := c.path(digest)
+	file, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.log.WithError(err).WithField("digest", digest).Warn("Error opening cached blob")
+		}
+		return nil, false
+	}
+
+	now := c.now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		c.log.WithError(err).WithField("digest", digest).Debug("Error recording use of cached blob")
+	}
+
+	return file, true
+}
+
+// Writer returns a writer that stores the blob with the digest once it is committed, if the content matches the
+// digest. It returns false if the digest cannot be cached.
+func (c *BlobCache) Writer(digest string) (*BlobWriter, bool) {
+	if !validDigest(digest) {
+		return nil, false
+	}
+
+	// The temporary directory is recreated in case another process removed it as stale
+	var file *os.File
+	err := os.MkdirAll(c.temp, 0700)
+	if err == nil {
+		file, err = c.createTemp(c.temp, "blob")
+	}
+	if err != nil {
+		c.log.WithError(err).WithField("digest", digest).Warn("Error creating cached blob")
+		return nil, false
+	}
+
+	return &BlobWriter{cache: c, digest: digest, file: file, hash: sha256.New()}, true
+}
+
+// commit moves a verified blob into the cache and evicts blobs if the cache is too large
+func (c *BlobCache) commit(digest string, temp string, size int64) error {
+	if size > c.maxSize {
+		return os.Remove(temp)
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	defer unlock()
+
+	path := c.path(digest)
+	now := c.now()
+
+	// Another process may have stored the same blob in the meantime
+	if _, err := os.Stat(path); err == nil {
+		_ = os.Chtimes(path, now, now)
+		return os.Remove(temp)
+	}
+
+	if err := os.Chtimes(temp, now, now); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+
+	c.evict()
+	return nil
+}
+
+// lock serializes changes to the cache with other goroutines and processes
+func (c *BlobCache) lock() (func(), error) {
+	c.mutex.Lock()
+
+	unlock, err := cache.LockFile(filepath.Join(c.dir, lockFileName))
+	if err != nil {
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlock()
+		c.mutex.Unlock()
+	}, nil
+}
+
+// evict removes the least recently used blobs until the cache is no larger than its maximum size, and returns the
+// number and total size of the remaining blobs. It must be called while holding the lock.
+func (c *BlobCache) evict() (int, int64) {
+	files, err := ioutil.ReadDir(filepath.Join(c.dir, digestAlgorithm))
+	if err != nil {
+		c.log.WithError(err).Warn("Error reading blob cache")
+		return 0, 0
+	}
+
+	var blobs []os.FileInfo
+	var size int64
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			blobs = append(blobs, file)
+			size += file.Size()
+		}
+	}
+
+	// The modification time of a blob is the time it was last used
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().Before(blobs[j].ModTime())
+	})
+
+	for len(blobs) > 0 && size > c.maxSize {
+		digest := digestAlgorithm + ":" + blobs[0].Name()
+		if err := os.Remove(c.path(digest)); err != nil && !os.IsNotExist(err) {
+			c.log.WithError(err).WithField("digest", digest).Warn("Error removing cached blob")
+		} else {
+			c.log.WithField("digest", digest).Debug("Evicted cached blob")
+		}
+
+		size -= blobs[0].Size()
+		blobs = blobs[1:]
+	}
+
+	return len(blobs), size
+}
+
+// removeStaleDownloads removes the temporary directories of processes that did not stop cleanly
+func (c *BlobCache) removeStaleDownloads() {
+	dirs, err := ioutil.ReadDir(filepath.Join(c.dir, "tmp"))
+	if err != nil {
+		c.log.WithError(err).Debug("Error reading temporary directory of blob cache")
+		return
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(c.dir, "tmp", dir.Name())
+		if path == c.temp || c.now().Sub(dir.ModTime()) < staleDownloadAge {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			c.log.WithError(err).WithField("dir", path).Debug("Error removing stale downloads")
+		}
+	}
+}
+
+func (c *BlobCache) path(digest string) string {
+	return filepath.Join(c.dir, digestAlgorithm, strings.TrimPrefix(digest, digestAlgorithm+":"))
+}
+
+// BlobWriter writes a blob to a temporary file while computing its digest
+type BlobWriter struct {
+	cache  *BlobCache
+	digest string
+	file   *os.File
+	hash   hash.Hash
+	size   int64
+}
+
+// Write writes part of the blob
+func (w *BlobWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	_, _ = w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// Commit stores the blob in the cache if its content matches its digest
+func (w *BlobWriter) Commit() error {
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+
+	if digest := fmt.Sprintf("%s:%x", digestAlgorithm, w.hash.Sum(nil)); digest != w.digest {
+		_ = os.Remove(w.file.Name())
+		return ErrDigestMismatch
+	}
+
+	return w.cache.commit(w.digest, w.file.Name(), w.size)
+}
+
+// Discard removes the incomplete blob
+func (w *BlobWriter) Discard() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+func validDigest(digest string) bool {
+	encoded := strings.TrimPrefix(digest, digestAlgorithm+":")
+	if encoded == digest || len(encoded) != sha256.Size*2 || strings.ToLower(encoded) != encoded {
+		return false
+	}
+
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobCache_Writer_StoresVerifiedBlob(t *testing.T) {
+	c, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	store(t, c, "layer")
+
+	require.Equal(t, "layer", read(t, c, digestOf("layer")))
+}
+
+func TestBlobCache_Writer_RejectsDigestMismatch(t *testing.T) {
+	c, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	writer, ok := c.Writer(digestOf("layer"))
+	require.True(t, ok)
+
+	_, err := writer.Write([]byte("other"))
+	require.NoError(t, err)
+
+	require.Equal(t, ErrDigestMismatch, writer.Commit())
+	_, ok = c.Open(digestOf("layer"))
+	require.False(t, ok)
+}
+
+func TestBlobCache_Writer_RejectsInvalidDigest(t *testing.T) {
+	c, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	for _, digest := range []string{"sha512:abcd", "sha256:abcd", strings.ToUpper(digestOf("layer"))} {
+		_, ok := c.Writer(digest)
+		require.False(t, ok, digest)
+	}
+}
+
+func TestBlobCache_Commit_EvictsLeastRecentlyUsedBlobs(t *testing.T) {
+	c, dir := newTestBlobCache(t, 10)
+	defer os.RemoveAll(dir)
+	store(t, c, "aaaa")
+	store(t, c, "bbbb")
+	read(t, c, digestOf("aaaa"))
+
+	store(t, c, "cccc")
+
+	_, ok := c.Open(digestOf("bbbb"))
+	require.False(t, ok)
+	require.Equal(t, "aaaa", read(t, c, digestOf("aaaa")))
+	require.Equal(t, "cccc", read(t, c, digestOf("cccc")))
+	require.Equal(t, int64(8), cachedSize(t, dir))
+}
+
+func TestBlobCache_Commit_SkipsBlobsLargerThanCache(t *testing.T) {
+	c, dir := newTestBlobCache(t, 2)
+	defer os.RemoveAll(dir)
+
+	store(t, c, "layer")
+
+	_, ok := c.Open(digestOf("layer"))
+	require.False(t, ok)
+}
+
+func TestBlobCache_New_LoadsExistingBlobs(t *testing.T) {
+	c, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	store(t, c, "layer")
+
+	reloaded, err := NewBlobCache(dir, 100)
+
+	require.NoError(t, err)
+	require.Equal(t, "layer", read(t, reloaded, digestOf("layer")))
+	require.Equal(t, int64(5), cachedSize(t, dir))
+}
+
+func TestBlobCache_New_KeepsDownloadsOfOtherProcesses(t *testing.T) {
+	c, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	writer, ok := c.Writer(digestOf("layer"))
+	require.True(t, ok)
+	_, err := writer.Write([]byte("layer"))
+	require.NoError(t, err)
+
+	other, err := NewBlobCache(dir, 100)
+	require.NoError(t, err)
+	defer other.Close()
+
+	require.NoError(t, writer.Commit())
+	require.Equal(t, "layer", read(t, other, digestOf("layer")))
+}
+
+func TestBlobCache_Commit_LimitsSizeAcrossProcesses(t *testing.T) {
+	c, dir := newTestBlobCache(t, 10)
+	defer os.RemoveAll(dir)
+	other, err := NewBlobCache(dir, 10)
+	require.NoError(t, err)
+	defer other.Close()
+	other.now = c.now
+	store(t, c, "aaaa")
+	store(t, other, "bbbb")
+
+	store(t, c, "cccc")
+
+	_, ok := other.Open(digestOf("aaaa"))
+	require.False(t, ok)
+	require.Equal(t, "bbbb", read(t, c, digestOf("bbbb")))
+	require.Equal(t, "cccc", read(t, other, digestOf("cccc")))
+	require.Equal(t, int64(8), cachedSize(t, dir))
+}
+
+func TestBlobCache_New_RemovesStaleDownloads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobcache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stale := filepath.Join(dir, "tmp", "downloads-stale")
+	require.NoError(t, os.MkdirAll(stale, 0700))
+	old := time.Now().Add(-2 * staleDownloadAge)
+	require.NoError(t, os.Chtimes(stale, old, old))
+
+	c, err := NewBlobCache(dir, 100)
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = os.Stat(stale)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestBlobCache_Close_RemovesTemporaryDirectory(t *testing.T) {
+	c, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, c.Close())
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func newTestBlobCache(t *testing.T, maxSize int64) (*BlobCache, string) {
+	dir, err := ioutil.TempDir("", "blobcache")
+	require.NoError(t, err)
+
+	c, err := NewBlobCache(dir, maxSize)
+	require.NoError(t, err)
+
+	// The clock advances on every use, so that the order of uses does not depend on the resolution of file times
+	now := time.Now()
+	c.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	return c, dir
+}
+
+func cachedSize(t *testing.T, dir string) int64 {
+	files, err := ioutil.ReadDir(filepath.Join(dir, digestAlgorithm))
+	require.NoError(t, err)
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+	return size
+}
+
+func store(t *testing.T, c *BlobCache, content string) {
+	writer, ok := c.Writer(digestOf(content))
+	require.True(t, ok)
+
+	_, err := writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Commit())
+}
+
+func read(t *testing.T, c *BlobCache, digest string) string {
+	file, ok := c.Open(digest)
+	require.True(t, ok)
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+
+	return string(content)
+}
+
+func digestOf(content string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+}
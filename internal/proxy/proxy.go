@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	log "github.com/sirupsen/logrus"
+)
+
+// refreshWindow is how long before its expiry the authorization token is replaced, so that requests in flight never
+// use an expired token
+const refreshWindow = 30 * time.Minute
+
+// maxRedirects is how many redirects are followed when downloading a blob, as many as the HTTP client follows by default
+const maxRedirects = 10
+
+// TokenSource returns a new authorization token for the ECR registry
+type TokenSource func() (*ecr.RegistryAuth, error)
+
+// blobPath matches the paths of blobs in the registry API
+var blobPath = regexp.MustCompile(`^/v2/.+/blobs/(sha256:[a-f0-9]{64})$`)
+
+// Proxy is a registry that forwards every request to an ECR registry, authenticating it with an authorization token
+// so that clients do not need any credentials
+type Proxy struct {
+	tokens TokenSource
+	blobs  *BlobCache
+	client *http.Client
+	log    *log.Entry
+	now    func() time.Time
+
+	mutex sync.Mutex
+	auth  *ecr.RegistryAuth
+}
+
+// New creates a proxy for the ECR registry of the tokens returned by 'tokens'. When 'blobs' is not nil, blobs are
+// served from and stored in it.
+func New(tokens TokenSource, blobs *BlobCache) *Proxy {
+	return &Proxy{
+		tokens: tokens,
+		blobs:  blobs,
+		client: &http.Client{CheckRedirect: withoutAuthorization},
+		log:    log.WithField("component", "proxy"),
+		now:    time.Now,
+	}
+}
+
+// ServeHTTP forwards the request to ECR with the current authorization token
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	digest := ""
+	if match := blobPath.FindStringSubmatch(r.URL.Path); match != nil && p.blobs != nil &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		digest = match[1]
+	}
+
+	if digest != "" {
+		if file, ok := p.blobs.Open(digest); ok {
+			defer file.Close()
+			p.log.WithField("digest", digest).Debug("Serving cached blob")
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Docker-Content-Digest", digest)
+			http.ServeContent(w, r, "", time.Time{}, file)
+			return
+		}
+	}
+
+	auth, err := p.token()
+	if err != nil {
+		p.log.WithError(err).Error("Error getting authorization token for ECR")
+		writeError(w, http.StatusBadGateway, "UNAVAILABLE", "unable to authenticate with ECR")
+		return
+	}
+
+	upstream, err := url.Parse(auth.ProxyEndpoint)
+	if err != nil {
+		p.log.WithError(err).Error("Error parsing ECR endpoint")
+		writeError(w, http.StatusBadGateway, "UNAVAILABLE", "invalid ECR endpoint")
+		return
+	}
+
+	p.log.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Forwarding request")
+
+	// Partial downloads are not cached, as the blob could not be verified
+	if digest != "" && r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+		p.fetchBlob(w, r, upstream, auth, digest)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(request *http.Request) {
+			request.URL.Scheme = upstream.Scheme
+			request.URL.Host = upstream.Host
+			request.Host = upstream.Host
+			request.Header.Set("Authorization", basicAuth(auth))
+		},
+		ModifyResponse: func(response *http.Response) error {
+			// Upload locations must point back at the proxy, while redirects to S3 for blobs are left as they are
+			if location := response.Header.Get("Location"); strings.HasPrefix(location, upstream.Scheme+"://"+upstream.Host+"/") {
+				response.Header.Set("Location", strings.TrimPrefix(location, upstream.Scheme+"://"+upstream.Host))
+			}
+			response.Header.Del("Www-Authenticate")
+			return nil
+		},
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// fetchBlob downloads the blob from ECR, following the redirect to S3 instead of returning it to the client, and
+// stores it in the blob cache while sending it to the client
+func (p *Proxy) fetchBlob(w http.ResponseWriter, r *http.Request, upstream *url.URL, auth *ecr.RegistryAuth, digest string) {
+	target := *r.URL
+	target.Scheme = upstream.Scheme
+	target.Host = upstream.Host
+
+	request, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "UNAVAILABLE", "unable to download blob")
+		return
+	}
+	request = request.WithContext(r.Context())
+
+	request.Header.Set("Authorization", basicAuth(auth))
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		p.log.WithError(err).WithField("digest", digest).Error("Error downloading blob")
+		writeError(w, http.StatusBadGateway, "UNAVAILABLE", "unable to download blob")
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		for _, header := range []string{"Content-Type", "Content-Length"} {
+			if value := response.Header.Get(header); value != "" {
+				w.Header().Set(header, value)
+			}
+		}
+		w.WriteHeader(response.StatusCode)
+		_, _ = io.Copy(w, response.Body)
+		return
+	}
+
+	if response.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(response.ContentLength, 10))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusOK)
+
+	writer, ok := p.blobs.Writer(digest)
+	if !ok {
+		_, _ = io.Copy(w, response.Body)
+		return
+	}
+
+	// Failing to cache the blob must not interrupt the download of the client
+	cached := &bestEffortWriter{writer: writer}
+	if _, err := io.Copy(w, io.TeeReader(response.Body, cached)); err != nil {
+		p.log.WithError(err).WithField("digest", digest).Warn("Error downloading blob")
+		writer.Discard()
+		return
+	}
+
+	if cached.err != nil {
+		p.log.WithError(cached.err).WithField("digest", digest).Warn("Error caching blob")
+		writer.Discard()
+		return
+	}
+
+	if err := writer.Commit(); err != nil {
+		p.log.WithError(err).WithField("digest", digest).Warn("Error caching blob")
+		return
+	}
+	p.log.WithField("digest", digest).Debug("Cached blob")
+}
+
+// bestEffortWriter writes to a blob writer until it fails, and then remembers the error instead of returning it
+type bestEffortWriter struct {
+	writer io.Writer
+	err    error
+}
+
+// Write writes to the blob writer unless it failed before, always reporting success
+func (w *bestEffortWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.writer.Write(p)
+	}
+	return len(p), nil
+}
+
+// token returns the current authorization token, replacing it when it is about to expire
+func (p *Proxy) token() (*ecr.RegistryAuth, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.auth != nil && p.now().Add(refreshWindow).Before(p.auth.ExpiresAt) {
+		return p.auth, nil
+	}
+
+	auth, err := p.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	p.log.WithFields(log.Fields{
+		"registry":  auth.ProxyEndpoint,
+		"expiresAt": auth.ExpiresAt.Format(time.RFC3339),
+	}).Info("Refreshed authorization token")
+
+	p.auth = auth
+	return auth, nil
+}
+
+// withoutAuthorization removes the Authorization header when a redirect leaves the registry, as S3 rejects pre-signed
+// URLs that are requested with it
+func withoutAuthorization(request *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	if request.URL.Host != via[0].URL.Host {
+		request.Header.Del("Authorization")
+	}
+
+	return nil
+}
+
+// basicAuth returns the Authorization header for the authorization token
+func basicAuth(auth *ecr.RegistryAuth) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password))
+}
+
+// writeError writes an error response in the format of the registry API
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hylandsoftware/trebuchet/internal/ecr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_ServeHTTP_ForwardsRequestWithCredentials(t *testing.T) {
+	var authorization, path string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		path = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), nil)
+
+	response := serve(p, http.MethodGet, "/v2/app/manifests/1.0")
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "Basic QVdTOnRva2Vu", authorization)
+	require.Equal(t, "/v2/app/manifests/1.0", path)
+}
+
+func TestProxy_ServeHTTP_RewritesUpstreamLocations(t *testing.T) {
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", upstream.URL+"/v2/app/blobs/uploads/1234")
+		w.Header().Set("Www-Authenticate", `Basic realm="https://ecr"`)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), nil)
+
+	response := serve(p, http.MethodPost, "/v2/app/blobs/uploads/")
+
+	require.Equal(t, http.StatusAccepted, response.Code)
+	require.Equal(t, "/v2/app/blobs/uploads/1234", response.Header().Get("Location"))
+	require.Empty(t, response.Header().Get("Www-Authenticate"))
+}
+
+func TestProxy_ServeHTTP_KeepsRedirectsToOtherHosts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://bucket.s3.amazonaws.com/layer", http.StatusTemporaryRedirect)
+	}))
+	defer upstream.Close()
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), nil)
+
+	response := serve(p, http.MethodGet, "/v2/app/blobs/sha256:abcd")
+
+	require.Equal(t, http.StatusTemporaryRedirect, response.Code)
+	require.Equal(t, "https://bucket.s3.amazonaws.com/layer", response.Header().Get("Location"))
+}
+
+func TestProxy_ServeHTTP_CachesBlobs(t *testing.T) {
+	var storageAuthorization []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageAuthorization = append(storageAuthorization, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte("layer"))
+	}))
+	defer storage.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, storage.URL+"/layer", http.StatusTemporaryRedirect)
+	}))
+	defer upstream.Close()
+	blobs, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), blobs)
+
+	for i := 0; i < 2; i++ {
+		response := serve(p, http.MethodGet, "/v2/app/blobs/"+digestOf("layer"))
+
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "layer", response.Body.String())
+		require.Equal(t, digestOf("layer"), response.Header().Get("Docker-Content-Digest"))
+	}
+	require.Equal(t, []string{""}, storageAuthorization)
+}
+
+func TestProxy_ServeHTTP_ServesCachedBlobsWithoutToken(t *testing.T) {
+	blobs, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	store(t, blobs, "layer")
+	p := New(func() (*ecr.RegistryAuth, error) {
+		return nil, errors.New("error")
+	}, blobs)
+
+	response := serve(p, http.MethodHead, "/v2/app/blobs/"+digestOf("layer"))
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "5", response.Header().Get("Content-Length"))
+}
+
+func TestProxy_ServeHTTP_DoesNotCacheMismatchingBlobs(t *testing.T) {
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte("other"))
+	}))
+	defer upstream.Close()
+	blobs, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), blobs)
+
+	serve(p, http.MethodGet, "/v2/app/blobs/"+digestOf("layer"))
+	serve(p, http.MethodGet, "/v2/app/blobs/"+digestOf("layer"))
+
+	require.Equal(t, 2, requests)
+}
+
+func TestProxy_ServeHTTP_ServesBlobsWhenCachingFails(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("layer"))
+	}))
+	defer upstream.Close()
+	blobs, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	// Writes to a file opened only for reading fail
+	blobs.createTemp = func(dir, pattern string) (*os.File, error) {
+		file, err := ioutil.TempFile(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		return os.Open(file.Name())
+	}
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), blobs)
+
+	response := serve(p, http.MethodGet, "/v2/app/blobs/"+digestOf("layer"))
+
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, "layer", response.Body.String())
+	_, ok := blobs.Open(digestOf("layer"))
+	require.False(t, ok)
+	entries, err := ioutil.ReadDir(blobs.temp)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestProxy_ServeHTTP_ForwardsBlobErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+	}))
+	defer upstream.Close()
+	blobs, dir := newTestBlobCache(t, 100)
+	defer os.RemoveAll(dir)
+	p := New(staticTokens(upstream.URL, time.Now().Add(12*time.Hour)), blobs)
+
+	response := serve(p, http.MethodGet, "/v2/app/blobs/"+digestOf("layer"))
+
+	require.Equal(t, http.StatusNotFound, response.Code)
+	require.Contains(t, response.Body.String(), "BLOB_UNKNOWN")
+}
+
+func TestProxy_Token_RefreshesBeforeExpiry(t *testing.T) {
+	calls := 0
+	p := New(func() (*ecr.RegistryAuth, error) {
+		calls++
+		return &ecr.RegistryAuth{ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}, nil)
+
+	_, err := p.token()
+	require.NoError(t, err)
+	_, err = p.token()
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	p.now = func() time.Time { return time.Now().Add(45 * time.Minute) }
+	_, err = p.token()
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
+
+func TestProxy_ServeHTTP_ReturnsBadGatewayOnTokenError(t *testing.T) {
+	p := New(func() (*ecr.RegistryAuth, error) {
+		return nil, errors.New("error")
+	}, nil)
+
+	response := serve(p, http.MethodGet, "/v2/")
+
+	require.Equal(t, http.StatusBadGateway, response.Code)
+	require.JSONEq(t, `{"errors": [{"code": "UNAVAILABLE", "message": "unable to authenticate with ECR"}]}`,
+		response.Body.String())
+}
+
+func staticTokens(endpoint string, expiresAt time.Time) TokenSource {
+	return func() (*ecr.RegistryAuth, error) {
+		return &ecr.RegistryAuth{
+			ProxyEndpoint: endpoint,
+			Username:      "AWS",
+			Password:      "token",
+			ExpiresAt:     expiresAt,
+		}, nil
+	}
+}
+
+func serve(handler http.Handler, method string, path string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(method, path, nil))
+	return response
+}
@@ -0,0 +1,149 @@
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxNameLength is the longest repository name, including the registry, accepted by Docker registries
+const maxNameLength = 255
+
+var (
+	ErrEmptyReference   = errors.New("image reference is empty")
+	ErrInvalidReference = errors.New("invalid image reference")
+	ErrNameTooLong      = errors.New("repository name must not be more than 255 characters")
+)
+
+var (
+	// registryPattern matches a registry host with an optional port, e.g. 'localhost:5000' or 'registry.example.com'
+	registryPattern = `(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?))*(?::[0-9]+)?`
+
+	// pathComponentPattern matches a single component of a repository path, e.g. 'my-app' or 'team_1'
+	pathComponentPattern = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+
+	registryRegexp     = regexp.MustCompile(`^` + registryPattern + `$`)
+	pathRegexp         = regexp.MustCompile(`^` + pathComponentPattern + `(?:/` + pathComponentPattern + `)*$`)
+	tagRegexp          = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp       = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+	sha256DigestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// Reference is a parsed Docker image reference of the form [REGISTRY[:PORT]/]PATH[:TAG][@DIGEST]
+type Reference struct {
+	Registry string
+	Path     string
+	Tag      string
+	Digest   string
+}
+
+// Parse parses an image reference such as 'app', 'team/app:1.0', 'localhost:5000/app:1.0', 'app@sha256:...' or
+// '112233445566.dkr.ecr.us-east-1.amazonaws.com/app:1.0'
+func Parse(image string) (Reference, error) {
+	if image == "" {
+		return Reference{}, ErrEmptyReference
+	}
+
+	var ref Reference
+	remainder := image
+
+	if i := strings.Index(remainder, "@"); i >= 0 {
+		ref.Digest = remainder[i+1:]
+		remainder = remainder[:i]
+
+		if !digestRegexp.MatchString(ref.Digest) ||
+			(strings.HasPrefix(ref.Digest, "sha256:") && !sha256DigestRegexp.MatchString(ref.Digest)) {
+			return Reference{}, invalid(image, "invalid digest")
+		}
+	}
+
+	// A colon after the last slash separates the tag; any earlier colon belongs to the registry port
+	if i := strings.LastIndex(remainder, ":"); i > strings.LastIndex(remainder, "/") {
+		ref.Tag = remainder[i+1:]
+		remainder = remainder[:i]
+
+		if !tagRegexp.MatchString(ref.Tag) {
+			return Reference{}, invalid(image, "invalid tag")
+		}
+	}
+
+	if len(remainder) > maxNameLength {
+		return Reference{}, ErrNameTooLong
+	}
+
+	if i := strings.Index(remainder, "/"); i >= 0 && isRegistry(remainder[:i]) {
+		ref.Registry = remainder[:i]
+		remainder = remainder[i+1:]
+
+		if !registryRegexp.MatchString(ref.Registry) {
+			return Reference{}, invalid(image, "invalid registry")
+		}
+	}
+
+	if !pathRegexp.MatchString(remainder) {
+		return Reference{}, invalid(image, "invalid repository path")
+	}
+	ref.Path = remainder
+
+	return ref, nil
+}
+
+// ValidateTag returns an error if 'tag' is not a valid image tag
+func ValidateTag(tag string) error {
+	if !tagRegexp.MatchString(tag) {
+		return invalid(tag, "invalid tag")
+	}
+	return nil
+}
+
+// Name returns the registry (if any) and path of the reference, without its tag or digest
+func (r Reference) Name() string {
+	if r.Registry == "" {
+		return r.Path
+	}
+	return r.Registry + "/" + r.Path
+}
+
+// TagOrDefault returns the tag of the reference, or 'latest' when it has none
+func (r Reference) TagOrDefault() string {
+	if r.Tag == "" {
+		return "latest"
+	}
+	return r.Tag
+}
+
+// Identifier returns the digest of the reference if it has one, otherwise its tag (defaulting to 'latest')
+func (r Reference) Identifier() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.TagOrDefault()
+}
+
+// InRepository returns a reference to the same tag and digest in another repository, such as an ECR repository URI
+func (r Reference) InRepository(repository string) string {
+	result := repository
+	if r.Tag != "" {
+		result += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		result += "@" + r.Digest
+	}
+	return result
+}
+
+// String returns the reference in its canonical textual form
+func (r Reference) String() string {
+	return r.InRepository(r.Name())
+}
+
+// isRegistry reports whether the first component of an image name is a registry host rather than part of the path,
+// following the same rule as the Docker CLI
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost" || strings.ToLower(component) != component
+}
+
+func invalid(image string, reason string) error {
+	return fmt.Errorf("%w '%s': %s", ErrInvalidReference, image, reason)
+}
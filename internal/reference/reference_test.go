@@ -0,0 +1,110 @@
+package reference
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestReference_Parse(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected Reference
+	}{
+		{"app", Reference{Path: "app"}},
+		{"app:1.0", Reference{Path: "app", Tag: "1.0"}},
+		{"team/app:1.0-beta_2", Reference{Path: "team/app", Tag: "1.0-beta_2"}},
+		{"some/deep/project/app", Reference{Path: "some/deep/project/app"}},
+		{"localhost/app", Reference{Registry: "localhost", Path: "app"}},
+		{"localhost:5000/app:1.0", Reference{Registry: "localhost:5000", Path: "app", Tag: "1.0"}},
+		{"localhost:5000/team/app", Reference{Registry: "localhost:5000", Path: "team/app"}},
+		{"registry.example.com/app:latest", Reference{Registry: "registry.example.com", Path: "app", Tag: "latest"}},
+		{"app@" + testDigest, Reference{Path: "app", Digest: testDigest}},
+		{"app:1.0@" + testDigest, Reference{Path: "app", Tag: "1.0", Digest: testDigest}},
+		{"localhost:5000/app@" + testDigest, Reference{Registry: "localhost:5000", Path: "app", Digest: testDigest}},
+		{"112233445566.dkr.ecr.us-east-1.amazonaws.com/hello/world:3.4-beta", Reference{
+			Registry: "112233445566.dkr.ecr.us-east-1.amazonaws.com", Path: "hello/world", Tag: "3.4-beta"}},
+		{"my.registry/sub-path__x", Reference{Registry: "my.registry", Path: "sub-path__x"}},
+		{"localhost:5000", Reference{Path: "localhost", Tag: "5000"}},
+		{"Upper/app", Reference{Registry: "Upper", Path: "app"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			result, err := Parse(test.image)
+
+			require.NoError(t, err)
+			require.Equal(t, test.expected, result)
+			require.Equal(t, test.image, result.String())
+		})
+	}
+}
+
+func TestReference_Parse_ReturnsErrors(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected error
+	}{
+		{"", ErrEmptyReference},
+		{"App:1.0", ErrInvalidReference},
+		{"app:", ErrInvalidReference},
+		{"app:-tag", ErrInvalidReference},
+		{"app:" + strings.Repeat("a", 129), ErrInvalidReference},
+		{"app@sha256:abc", ErrInvalidReference},
+		{"app@sha256:" + strings.ToUpper(testDigest[7:]), ErrInvalidReference},
+		{"my.app_name/app", ErrInvalidReference},
+		{"app@", ErrInvalidReference},
+		{"/app", ErrInvalidReference},
+		{"app/", ErrInvalidReference},
+		{"team//app", ErrInvalidReference},
+		{"localhost:port/app", ErrInvalidReference},
+		{"-registry.com/app", ErrInvalidReference},
+		{"app--", ErrInvalidReference},
+		{strings.Repeat("a", 256), ErrNameTooLong},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			_, err := Parse(test.image)
+
+			require.True(t, errors.Is(err, test.expected), "expected %v, got %v", test.expected, err)
+		})
+	}
+}
+
+func TestReference_InRepository(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{"app", "ecr.com/app"},
+		{"localhost:5000/app:1.0", "ecr.com/app:1.0"},
+		{"app@" + testDigest, "ecr.com/app@" + testDigest},
+		{"app:1.0@" + testDigest, "ecr.com/app:1.0@" + testDigest},
+	}
+
+	for _, test := range tests {
+		ref, err := Parse(test.image)
+		require.NoError(t, err)
+
+		require.Equal(t, test.expected, ref.InRepository("ecr.com/app"))
+	}
+}
+
+func TestReference_Identifier(t *testing.T) {
+	require.Equal(t, "latest", Reference{Path: "app"}.Identifier())
+	require.Equal(t, "1.0", Reference{Path: "app", Tag: "1.0"}.Identifier())
+	require.Equal(t, testDigest, Reference{Path: "app", Tag: "1.0", Digest: testDigest}.Identifier())
+}
+
+func TestReference_ValidateTag(t *testing.T) {
+	require.NoError(t, ValidateTag("1.2.3-rc1"))
+	require.NoError(t, ValidateTag("latest"))
+	require.True(t, errors.Is(ValidateTag(""), ErrInvalidReference))
+	require.True(t, errors.Is(ValidateTag(".hidden"), ErrInvalidReference))
+	require.True(t, errors.Is(ValidateTag("a/b"), ErrInvalidReference))
+}
@@ -0,0 +1,107 @@
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// versionRegexp matches tags of the form [v]MAJOR.MINOR.PATCH[-PRERELEASE]. Build metadata is not supported, as '+' is
+// not allowed in Docker tags.
+var versionRegexp = regexp.MustCompile(`^(v?)(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+// Version is a semantic version parsed from an image tag
+type Version struct {
+	Prefix     string
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// Parse parses a semantic version tag such as '1.4.2', 'v1.4.2' or '1.5.0-rc1'. It returns false if the tag is not a
+// semantic version.
+func Parse(tag string) (Version, bool) {
+	matches := versionRegexp.FindStringSubmatch(tag)
+	if matches == nil {
+		return Version{}, false
+	}
+
+	major, _ := strconv.Atoi(matches[2])
+	minor, _ := strconv.Atoi(matches[3])
+	patch, _ := strconv.Atoi(matches[4])
+
+	return Version{
+		Prefix:     matches[1],
+		Major:      major,
+		Minor:      minor,
+		Patch:      patch,
+		Prerelease: matches[5],
+	}, true
+}
+
+// Compare returns -1, 0 or 1 when the version is lower than, equal to or higher than 'other'. A pre-release is lower
+// than the release with the same numbers, and pre-releases of the same numbers are compared lexically.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	case v.Prerelease < other.Prerelease:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Expand returns the floating tags that 'tag' should also be published as: MAJOR.MINOR and MAJOR, plus 'latest' when
+// 'latest' is set. A floating tag is only included when no release in 'existing' is higher than 'tag' within the
+// range the floating tag covers, so pushing an older patch never moves it backwards. Pre-releases never move floating
+// tags, and tags that are not semantic versions are not expanded.
+func Expand(tag string, existing []string, latest bool) []string {
+	version, ok := Parse(tag)
+	if !ok || version.Prerelease != "" {
+		return nil
+	}
+
+	highestMinor, highestMajor, highest := true, true, true
+	for _, existingTag := range existing {
+		other, ok := Parse(existingTag)
+		if !ok || other.Prerelease != "" || other.Compare(version) <= 0 {
+			continue
+		}
+
+		highest = false
+		if other.Major == version.Major {
+			highestMajor = false
+			if other.Minor == version.Minor {
+				highestMinor = false
+			}
+		}
+	}
+
+	var tags []string
+	if highestMinor {
+		tags = append(tags, fmt.Sprintf("%s%d.%d", version.Prefix, version.Major, version.Minor))
+	}
+	if highestMajor {
+		tags = append(tags, fmt.Sprintf("%s%d", version.Prefix, version.Major))
+	}
+	if latest && highest {
+		tags = append(tags, "latest")
+	}
+
+	return tags
+}
@@ -0,0 +1,76 @@
+package semver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemver_Parse(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected Version
+		ok       bool
+	}{
+		{"1.4.2", Version{Major: 1, Minor: 4, Patch: 2}, true},
+		{"v10.0.1", Version{Prefix: "v", Major: 10, Minor: 0, Patch: 1}, true},
+		{"1.5.0-rc1", Version{Major: 1, Minor: 5, Patch: 0, Prerelease: "rc1"}, true},
+		{"1.5.0-beta.2", Version{Major: 1, Minor: 5, Patch: 0, Prerelease: "beta.2"}, true},
+		{"1.4", Version{}, false},
+		{"01.4.2", Version{}, false},
+		{"latest", Version{}, false},
+		{"1.4.2-", Version{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			result, ok := Parse(test.tag)
+
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestSemver_Compare(t *testing.T) {
+	parse := func(tag string) Version {
+		v, ok := Parse(tag)
+		require.True(t, ok)
+		return v
+	}
+
+	require.Equal(t, -1, parse("1.4.2").Compare(parse("1.10.0")))
+	require.Equal(t, 1, parse("2.0.0").Compare(parse("1.99.99")))
+	require.Equal(t, 0, parse("1.4.2").Compare(parse("v1.4.2")))
+	require.Equal(t, -1, parse("1.5.0-rc1").Compare(parse("1.5.0")))
+	require.Equal(t, 1, parse("1.5.0").Compare(parse("1.5.0-rc1")))
+	require.Equal(t, -1, parse("1.5.0-rc1").Compare(parse("1.5.0-rc2")))
+}
+
+func TestSemver_Expand(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      string
+		existing []string
+		latest   bool
+		expected []string
+	}{
+		{"empty repository", "1.4.2", nil, true, []string{"1.4", "1", "latest"}},
+		{"latest not requested", "1.4.2", nil, false, []string{"1.4", "1"}},
+		{"highest release", "1.4.2", []string{"1.4.1", "1.3.9", "0.9.0", "latest"}, true, []string{"1.4", "1", "latest"}},
+		{"older patch", "1.4.2", []string{"1.4.3"}, true, nil},
+		{"older minor", "1.4.2", []string{"1.5.0"}, true, []string{"1.4"}},
+		{"older major", "1.4.2", []string{"2.0.0"}, true, []string{"1.4", "1"}},
+		{"republished version", "1.4.2", []string{"1.4.2"}, true, []string{"1.4", "1", "latest"}},
+		{"higher pre-release", "1.4.2", []string{"1.5.0-rc1", "2.0.0-beta"}, true, []string{"1.4", "1", "latest"}},
+		{"pre-release", "1.5.0-rc1", nil, true, nil},
+		{"prefixed", "v1.4.2", []string{"v1.4.1"}, false, []string{"v1.4", "v1"}},
+		{"not a version", "build-123", nil, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, Expand(test.tag, test.existing, test.latest))
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package sso
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Profile is the AWS IAM Identity Center (SSO) configuration of a profile in the AWS config file
+type Profile struct {
+	// Session is the name of the sso-session section of the profile, which is empty for profiles that configure the
+	// start URL and region themselves
+	Session   string
+	StartURL  string
+	Region    string
+	AccountID string
+	RoleName  string
+}
+
+// ConfigFile returns the path of the AWS config file, which is $AWS_CONFIG_FILE or ~/.aws/config
+func ConfigFile() (string, error) {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".aws", "config"), nil
+}
+
+// LoadProfile reads the SSO configuration of the profile from the AWS config file in 'path'. It returns nil if the
+// file or the profile does not exist, or the profile does not use SSO.
+func LoadProfile(path string, name string) (*Profile, error) {
+	sections, err := readConfigFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	section := "profile " + name
+	if name == "default" {
+		if _, ok := sections[section]; !ok {
+			section = "default"
+		}
+	}
+
+	values, ok := sections[section]
+	if !ok || values["sso_account_id"] == "" || values["sso_role_name"] == "" {
+		return nil, nil
+	}
+
+	profile := &Profile{
+		Session:   values["sso_session"],
+		StartURL:  values["sso_start_url"],
+		Region:    values["sso_region"],
+		AccountID: values["sso_account_id"],
+		RoleName:  values["sso_role_name"],
+	}
+
+	if profile.Session != "" {
+		session := sections["sso-session "+profile.Session]
+		profile.StartURL = session["sso_start_url"]
+		profile.Region = session["sso_region"]
+	}
+
+	if profile.StartURL == "" || profile.Region == "" {
+		return nil, ErrIncompleteProfile
+	}
+
+	return profile, nil
+}
+
+// readConfigFile returns the keys and values of every section of an INI file. Nested values, such as the settings
+// of the S3 commands of the AWS CLI, are read as if they were not nested, which is sufficient for the SSO keys.
+func readConfigFile(path string) (map[string]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sections := map[string]map[string]string{}
+	var current map[string]string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			current = map[string]string{}
+			sections[name] = current
+		case current != nil:
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) == 2 {
+				current[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+
+	return sections, scanner.Err()
+}
@@ -0,0 +1,103 @@
+package sso
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `[default]
+region = us-east-1
+
+[profile dev-sso]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 112233445566
+sso_role_name = Developer
+region = eu-west-1
+
+[profile prod]
+sso_session = company
+sso_account_id = 998877665544
+sso_role_name = ReadOnly
+
+[sso-session company]
+sso_start_url = https://company.awsapps.com/start
+sso_region = eu-central-1
+
+[profile incomplete]
+sso_account_id = 112233445566
+sso_role_name = Developer
+`
+
+func TestSSO_LoadProfile_ReadsLegacyProfile(t *testing.T) {
+	path, dir := writeConfig(t, testConfig)
+	defer os.RemoveAll(dir)
+
+	profile, err := LoadProfile(path, "dev-sso")
+
+	require.NoError(t, err)
+	require.Equal(t, &Profile{
+		StartURL:  "https://example.awsapps.com/start",
+		Region:    "us-east-1",
+		AccountID: "112233445566",
+		RoleName:  "Developer",
+	}, profile)
+}
+
+func TestSSO_LoadProfile_ReadsSessionOfProfile(t *testing.T) {
+	path, dir := writeConfig(t, testConfig)
+	defer os.RemoveAll(dir)
+
+	profile, err := LoadProfile(path, "prod")
+
+	require.NoError(t, err)
+	require.Equal(t, &Profile{
+		Session:   "company",
+		StartURL:  "https://company.awsapps.com/start",
+		Region:    "eu-central-1",
+		AccountID: "998877665544",
+		RoleName:  "ReadOnly",
+	}, profile)
+}
+
+func TestSSO_LoadProfile_ReturnsNilForOtherProfiles(t *testing.T) {
+	path, dir := writeConfig(t, testConfig)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"default", "does-not-exist"} {
+		profile, err := LoadProfile(path, name)
+
+		require.NoError(t, err)
+		require.Nil(t, profile)
+	}
+}
+
+func TestSSO_LoadProfile_ReturnsNilForMissingFile(t *testing.T) {
+	profile, err := LoadProfile("/does/not/exist", "dev-sso")
+
+	require.NoError(t, err)
+	require.Nil(t, profile)
+}
+
+func TestSSO_LoadProfile_RejectsIncompleteProfile(t *testing.T) {
+	path, dir := writeConfig(t, testConfig)
+	defer os.RemoveAll(dir)
+
+	_, err := LoadProfile(path, "incomplete")
+
+	require.Equal(t, ErrIncompleteProfile, err)
+}
+
+func writeConfig(t *testing.T, content string) (string, string) {
+	dir, err := ioutil.TempDir("", "sso")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path, dir
+}
@@ -0,0 +1,123 @@
+package sso
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	awssts "github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/hylandsoftware/trebuchet/internal/logging"
+	"github.com/hylandsoftware/trebuchet/internal/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrIncompleteProfile = errors.New("SSO profile requires sso_start_url and sso_region, directly or in its sso-session")
+	ErrTokenExpired      = errors.New("SSO session has expired or does not exist; run aws sso login to sign in")
+)
+
+// legacyExpiresAtFormat is the format of token expiry times written by older versions of the AWS CLI
+const legacyExpiresAtFormat = "2006-01-02T15:04:05UTC"
+
+// cachedToken is an access token in the SSO token cache of the AWS CLI
+type cachedToken struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+// CacheDir returns the directory in which the AWS CLI caches SSO access tokens, ~/.aws/sso/cache
+func CacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".aws", "sso", "cache"), nil
+}
+
+// NewCredentialsProvider returns a provider of the credentials of the profile's account and role. They are obtained
+// from the SSO portal with the access token that aws sso login stored in 'cacheDir', and are refreshed the same way
+// before they expire, for as long as the SSO session lasts.
+func NewCredentialsProvider(config aws.Config, profile Profile, cacheDir string) (*sts.CredentialsProvider, error) {
+	config = config.Copy()
+	config.Region = profile.Region
+
+	entry := log.WithFields(log.Fields{
+		"component": "sso",
+		"account":   profile.AccountID,
+		"role":      profile.RoleName,
+	})
+	client := sso.New(config)
+	client.Handlers.Complete.PushBack(logging.RequestLogger(entry))
+
+	return sts.NewRefreshingCredentialsProvider(func() (*awssts.Credentials, error) {
+		token, err := readToken(cacheDir, profile, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := client.GetRoleCredentialsRequest(&sso.GetRoleCredentialsInput{
+			AccessToken: aws.String(token),
+			AccountId:   aws.String(profile.AccountID),
+			RoleName:    aws.String(profile.RoleName),
+		}).Send(context.Background())
+		if err != nil {
+			entry.Info("Error getting SSO role credentials")
+			return nil, err
+		}
+		if out.RoleCredentials == nil {
+			return nil, sts.ErrNoSTSCredentialsFound
+		}
+
+		entry.Info("Successfully got SSO role credentials")
+		return &awssts.Credentials{
+			AccessKeyId:     out.RoleCredentials.AccessKeyId,
+			SecretAccessKey: out.RoleCredentials.SecretAccessKey,
+			SessionToken:    out.RoleCredentials.SessionToken,
+			Expiration:      aws.Time(time.Unix(0, aws.Int64Value(out.RoleCredentials.Expiration)*int64(time.Millisecond))),
+		}, nil
+	}, 0)
+}
+
+// readToken returns the cached access token of the profile's SSO session, which is stored under the SHA-1 hash of
+// the session name, or of the start URL for profiles without a session
+func readToken(cacheDir string, profile Profile, now time.Time) (string, error) {
+	key := profile.StartURL
+	if profile.Session != "" {
+		key = profile.Session
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(cacheDir, fmt.Sprintf("%x.json", sha1.Sum([]byte(key)))))
+	if os.IsNotExist(err) {
+		return "", ErrTokenExpired
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var token cachedToken
+	if err := json.Unmarshal(content, &token); err != nil {
+		return "", err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		if expiresAt, err = time.Parse(legacyExpiresAtFormat, token.ExpiresAt); err != nil {
+			return "", err
+		}
+	}
+
+	if token.AccessToken == "" || !now.Before(expiresAt) {
+		return "", ErrTokenExpired
+	}
+
+	return token.AccessToken, nil
+}
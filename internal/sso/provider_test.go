@@ -0,0 +1,107 @@
+package sso
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/stretchr/testify/require"
+)
+
+var testProfile = Profile{
+	StartURL:  "https://example.awsapps.com/start",
+	Region:    "us-east-1",
+	AccountID: "112233445566",
+	RoleName:  "Developer",
+}
+
+func TestSSO_NewCredentialsProvider_ExchangesCachedToken(t *testing.T) {
+	dir := writeToken(t, testProfile.StartURL, `{"accessToken": "sso-token", "expiresAt": "2099-01-01T00:00:00Z"}`)
+	defer os.RemoveAll(dir)
+	var request *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"roleCredentials": {
+			"accessKeyId": "ASIASSO",
+			"secretAccessKey": "secret",
+			"sessionToken": "token",
+			"expiration": 4102444800000
+		}}`))
+	}))
+	defer server.Close()
+	config := defaults.Config()
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+	provider, err := NewCredentialsProvider(config, testProfile, dir)
+	require.NoError(t, err)
+	credentials, err := provider.Retrieve(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, "ASIASSO", credentials.AccessKeyID)
+	require.Equal(t, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), credentials.Expires.UTC())
+	require.Equal(t, "/federation/credentials", request.URL.Path)
+	require.Equal(t, "112233445566", request.URL.Query().Get("account_id"))
+	require.Equal(t, "Developer", request.URL.Query().Get("role_name"))
+	require.Equal(t, "sso-token", request.Header.Get("X-Amz-Sso_bearer_token"))
+}
+
+func TestSSO_ReadToken_UsesSessionName(t *testing.T) {
+	dir := writeToken(t, "company", `{"accessToken": "sso-token", "expiresAt": "2099-01-01T00:00:00Z"}`)
+	defer os.RemoveAll(dir)
+	profile := testProfile
+	profile.Session = "company"
+
+	token, err := readToken(dir, profile, time.Now())
+
+	require.NoError(t, err)
+	require.Equal(t, "sso-token", token)
+}
+
+func TestSSO_ReadToken_AcceptsLegacyExpiryFormat(t *testing.T) {
+	dir := writeToken(t, testProfile.StartURL, `{"accessToken": "sso-token", "expiresAt": "2099-01-01T00:00:00UTC"}`)
+	defer os.RemoveAll(dir)
+
+	token, err := readToken(dir, testProfile, time.Now())
+
+	require.NoError(t, err)
+	require.Equal(t, "sso-token", token)
+}
+
+func TestSSO_ReadToken_ReturnsErrTokenExpired(t *testing.T) {
+	dir := writeToken(t, testProfile.StartURL, `{"accessToken": "sso-token", "expiresAt": "2020-01-01T00:00:00Z"}`)
+	defer os.RemoveAll(dir)
+
+	_, err := readToken(dir, testProfile, time.Now())
+
+	require.Equal(t, ErrTokenExpired, err)
+}
+
+func TestSSO_ReadToken_ReturnsErrTokenExpiredWithoutToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = readToken(dir, testProfile, time.Now())
+
+	require.Equal(t, ErrTokenExpired, err)
+}
+
+func writeToken(t *testing.T, key string, content string) string {
+	dir, err := ioutil.TempDir("", "sso")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, fmt.Sprintf("%x.json", sha1.Sum([]byte(key))))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return dir
+}
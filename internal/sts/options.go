@@ -0,0 +1,125 @@
+package sts
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultRefreshWindow is how long before their expiry the credentials of assumed roles are refreshed by default
+const defaultRefreshWindow = 5 * time.Minute
+
+// maxSessionNameLength is the longest role session name STS accepts
+const maxSessionNameLength = 64
+
+// invalidSessionNameCharacters matches the characters STS does not accept in role session names
+var invalidSessionNameCharacters = regexp.MustCompile(`[^\w+=,.@-]+`)
+
+// AssumeRoleOptions are the parameters of role assumption besides the role itself
+type AssumeRoleOptions struct {
+	// SessionName is the name of the role session, which CloudTrail records with every request. When empty,
+	// DefaultSessionName is used.
+	SessionName string
+	// ExternalID is the external ID that third-party accounts may require in the trust policy of their roles
+	ExternalID string
+	// Duration is how long the credentials are valid for. When zero, STS uses its default of one hour.
+	Duration time.Duration
+	// MFASerial is the serial number or ARN of the MFA device required by the role, if any
+	MFASerial string
+	// MFAToken returns the current code of the MFA device. It is only called when the role is actually assumed, so
+	// that cached credentials do not require a new code.
+	MFAToken func() (string, error)
+	// Tags are session tags passed when assuming the role, which IAM policies can use for attribute-based access
+	// control as aws:PrincipalTag
+	Tags map[string]string
+	// TransitiveTagKeys are the keys of the tags that are kept when the session assumes another role
+	TransitiveTagKeys []string
+	// SourceIdentity identifies the person or pipeline that assumed the role. It is recorded in CloudTrail and kept
+	// in every session of a role chain.
+	SourceIdentity string
+	// RefreshWindow is how long before their expiry the credentials are refreshed by assuming the role again. When
+	// zero, defaultRefreshWindow is used.
+	RefreshWindow time.Duration
+}
+
+func (o AssumeRoleOptions) sessionName() string {
+	if o.SessionName != "" {
+		return o.SessionName
+	}
+
+	return DefaultSessionName()
+}
+
+// tags returns the session tags sorted by key, so that requests and cache keys do not depend on the order of the map
+func (o AssumeRoleOptions) tags() []sts.Tag {
+	keys := make([]string, 0, len(o.Tags))
+	for key := range o.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]sts.Tag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, sts.Tag{Key: aws.String(key), Value: aws.String(o.Tags[key])})
+	}
+
+	return tags
+}
+
+// cacheKey returns the parts of the options that determine the session, for the keys of cached credentials
+func (o AssumeRoleOptions) cacheKey() []string {
+	key := []string{o.sessionName(), o.ExternalID, o.SourceIdentity}
+	for _, tag := range o.tags() {
+		key = append(key, aws.StringValue(tag.Key)+"="+aws.StringValue(tag.Value))
+	}
+
+	transitiveTagKeys := append([]string(nil), o.TransitiveTagKeys...)
+	sort.Strings(transitiveTagKeys)
+	return append(key, transitiveTagKeys...)
+}
+
+func (o AssumeRoleOptions) durationSeconds() *int64 {
+	if o.Duration <= 0 {
+		return nil
+	}
+
+	return aws.Int64(int64(o.Duration / time.Second))
+}
+
+// DefaultSessionName returns a role session name that identifies the CI job trebuchet runs in, such as
+// jenkins-app-main-42 for Jenkins or github-org-app-1234567 for GitHub Actions, so that CloudTrail shows which
+// pipeline made a request. Outside of a known CI system the session name is TrebuchetAssumedRole.
+func DefaultSessionName() string {
+	return sessionNameFromEnvironment(os.Getenv)
+}
+
+func sessionNameFromEnvironment(getenv func(string) string) string {
+	var name string
+	switch {
+	case getenv("BUILD_TAG") != "":
+		name = getenv("BUILD_TAG")
+	case getenv("GITHUB_RUN_ID") != "":
+		name = "github-" + getenv("GITHUB_REPOSITORY") + "-" + getenv("GITHUB_RUN_ID")
+	case getenv("CI_JOB_ID") != "":
+		name = "gitlab-" + getenv("CI_PROJECT_PATH") + "-" + getenv("CI_JOB_ID")
+	default:
+		return roleSessionName
+	}
+
+	return SanitizeSessionName(name)
+}
+
+// SanitizeSessionName replaces the characters STS does not accept in role session names and shortens the name to
+// the maximum length, keeping its end, which usually holds the build number
+func SanitizeSessionName(name string) string {
+	name = invalidSessionNameCharacters.ReplaceAllString(name, "-")
+	if len(name) > maxSessionNameLength {
+		name = name[len(name)-maxSessionNameLength:]
+	}
+
+	return name
+}
@@ -0,0 +1,43 @@
+package sts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStsClient_SessionNameFromEnvironment(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		expected string
+	}{
+		{map[string]string{}, "TrebuchetAssumedRole"},
+		{map[string]string{"BUILD_TAG": "jenkins-app-main-42"}, "jenkins-app-main-42"},
+		{map[string]string{"GITHUB_RUN_ID": "1234", "GITHUB_REPOSITORY": "org/app"}, "github-org-app-1234"},
+		{map[string]string{"CI_JOB_ID": "99", "CI_PROJECT_PATH": "group/app"}, "gitlab-group-app-99"},
+	}
+
+	for _, test := range tests {
+		name := sessionNameFromEnvironment(func(key string) string { return test.env[key] })
+
+		require.Equal(t, test.expected, name)
+	}
+}
+
+func TestStsClient_SanitizeSessionName_KeepsEndOfLongNames(t *testing.T) {
+	name := SanitizeSessionName("jenkins-" + strings.Repeat("folder/", 10) + "app-42")
+
+	require.Len(t, name, maxSessionNameLength)
+	require.True(t, strings.HasSuffix(name, "folder-app-42"))
+	require.NotContains(t, name, "/")
+}
+
+func TestStsClient_AssumeRoleOptions_CacheKeyDependsOnTagsButNotTheirOrder(t *testing.T) {
+	options := AssumeRoleOptions{SessionName: "ci", Tags: map[string]string{"team": "platform", "env": "ci"}}
+	other := AssumeRoleOptions{SessionName: "ci", Tags: map[string]string{"env": "ci", "team": "platform"}}
+	different := AssumeRoleOptions{SessionName: "ci", Tags: map[string]string{"team": "apps", "env": "ci"}}
+
+	require.Equal(t, options.cacheKey(), other.cacheKey())
+	require.NotEqual(t, options.cacheKey(), different.cacheKey())
+}
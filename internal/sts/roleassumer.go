@@ -2,31 +2,87 @@ package sts
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	"github.com/hylandsoftware/trebuchet/internal/logging"
 	log "github.com/sirupsen/logrus"
 )
 
-var ErrNoSTSCredentialsFound = errors.New("no STS credentials were found")
+var (
+	ErrNoSTSCredentialsFound = errors.New("no STS credentials were found")
+	ErrNoMFAToken            = errors.New("an MFA token is required to assume the role")
+	ErrMFASessionExpired     = errors.New("credentials of the role assumed with MFA have expired and cannot be refreshed without a new MFA code")
+)
+
+// roleSessionName is the name of the sessions of assumed roles outside of CI systems, which appears in CloudTrail
+const roleSessionName = "TrebuchetAssumedRole"
 
 type RoleAssumer interface {
-	AssumeRole(config aws.Config, assumeRole string) (*CredentialsProvider, error)
+	AssumeRole(config aws.Config, assumeRole string, options AssumeRoleOptions) (*CredentialsProvider, error)
+	// AssumeRoleWithWebIdentity assumes a role with the OpenID Connect token in 'tokenFile', such as a GitHub Actions
+	// or Kubernetes service account token, without any AWS credentials. Only the session name, duration and source
+	// identity of the options apply, as session tags are taken from the token.
+	AssumeRoleWithWebIdentity(config aws.Config, assumeRole string, tokenFile string, options AssumeRoleOptions) (*CredentialsProvider, error)
 }
 
 type stsRoleAssumer struct {
 	log *log.Entry
 }
 
-func (r *stsRoleAssumer) AssumeRole(config aws.Config, assumeRole string) (*CredentialsProvider, error) {
-	stsClient := sts.New(config)
+func (r *stsRoleAssumer) AssumeRole(config aws.Config, assumeRole string, options AssumeRoleOptions) (*CredentialsProvider, error) {
+	return newRoleCredentialsProvider(func() (*sts.Credentials, error) {
+		return r.assumeRole(config, assumeRole, options)
+	}, options)
+}
+
+func (r *stsRoleAssumer) assumeRole(config aws.Config, assumeRole string, options AssumeRoleOptions) (*sts.Credentials, error) {
+	stsClient := newClient(config, r.log)
 
 	input := &sts.AssumeRoleInput{
 		RoleArn:         aws.String(assumeRole),
-		RoleSessionName: aws.String("TrebuchetAssumedRole"),
+		RoleSessionName: aws.String(options.sessionName()),
+		DurationSeconds: options.durationSeconds(),
 	}
-	out, err := stsClient.AssumeRoleRequest(input).Send(context.Background())
+	if options.ExternalID != "" {
+		input.ExternalId = aws.String(options.ExternalID)
+	}
+	if len(options.Tags) > 0 {
+		input.Tags = options.tags()
+		input.TransitiveTagKeys = options.TransitiveTagKeys
+	}
+	if options.MFASerial != "" {
+		if options.MFAToken == nil {
+			return nil, ErrNoMFAToken
+		}
+
+		token, err := options.MFAToken()
+		if err != nil {
+			return nil, err
+		}
+
+		input.SerialNumber = aws.String(options.MFASerial)
+		input.TokenCode = aws.String(token)
+	}
+
+	r.log.WithFields(log.Fields{
+		"role":        assumeRole,
+		"sessionName": aws.StringValue(input.RoleSessionName),
+	}).Debug("Assuming role")
+	request := stsClient.AssumeRoleRequest(input)
+	if options.SourceIdentity != "" {
+		request.Handlers.Build.PushBack(withSourceIdentity(options.SourceIdentity))
+	}
+	out, err := request.Send(context.Background())
 
 	if err != nil {
 		r.log.WithField("role", assumeRole).Info("Error attempting to assume role")
@@ -34,7 +90,172 @@ func (r *stsRoleAssumer) AssumeRole(config aws.Config, assumeRole string) (*Cred
 	}
 
 	r.log.WithField("role", assumeRole).Info("Successfully assumed role")
-	return &CredentialsProvider{Credentials: out.Credentials}, nil
+	return out.Credentials, nil
+}
+
+func (r *stsRoleAssumer) AssumeRoleWithWebIdentity(config aws.Config, assumeRole string, tokenFile string, options AssumeRoleOptions) (*CredentialsProvider, error) {
+	return NewRefreshingCredentialsProvider(func() (*sts.Credentials, error) {
+		return r.assumeRoleWithWebIdentity(config, assumeRole, tokenFile, options)
+	}, options.RefreshWindow)
+}
+
+// assumeRoleWithWebIdentity reads the token file every time, as tokens of Kubernetes service accounts are rotated
+func (r *stsRoleAssumer) assumeRoleWithWebIdentity(config aws.Config, assumeRole string, tokenFile string, options AssumeRoleOptions) (*sts.Credentials, error) {
+	token, err := readWebIdentityToken(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	request := newClient(config, r.log).AssumeRoleWithWebIdentityRequest(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(assumeRole),
+		RoleSessionName:  aws.String(options.sessionName()),
+		DurationSeconds:  options.durationSeconds(),
+		WebIdentityToken: aws.String(token),
+	})
+	if options.SourceIdentity != "" {
+		request.Handlers.Build.PushBack(withSourceIdentity(options.SourceIdentity))
+	}
+	out, err := request.Send(context.Background())
+
+	if err != nil {
+		r.log.WithField("role", assumeRole).Info("Error attempting to assume role with web identity")
+		return nil, err
+	}
+
+	r.log.WithField("role", assumeRole).Info("Successfully assumed role with web identity")
+	return out.Credentials, nil
+}
+
+// newClient creates an STS client that logs the request ID of every request to 'entry'
+func newClient(config aws.Config, entry *log.Entry) *sts.Client {
+	client := sts.New(config)
+	client.Handlers.Complete.PushBack(logging.RequestLogger(entry))
+	return client
+}
+
+// withSourceIdentity returns a build handler that adds the SourceIdentity parameter to an STS request, as the version
+// of the AWS SDK in use predates it. The parameter is added to the form of the query protocol request before it is
+// signed.
+func withSourceIdentity(sourceIdentity string) func(*aws.Request) {
+	return func(r *aws.Request) {
+		if r.Error != nil {
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			r.Error = err
+			return
+		}
+
+		values.Set("SourceIdentity", sourceIdentity)
+		r.SetBufferBody([]byte(values.Encode()))
+	}
+}
+
+func readWebIdentityToken(tokenFile string) (string, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+// CallerAccount returns the ID of the AWS account that the credentials in 'config' belong to
+func CallerAccount(config aws.Config) (string, error) {
+	out, err := newClient(config, log.WithField("component", "sts")).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{}).Send(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(out.Account), nil
+}
+
+// cachingRoleAssumer reuses assumed role credentials from a cache until they expire
+type cachingRoleAssumer struct {
+	RoleAssumer
+	cache *cache.Cache
+}
+
+// NewCachingRoleAssumer returns a role assumer that caches the credentials of roles assumed by 'assumer', per role
+// and per the credentials used to assume it
+func NewCachingRoleAssumer(assumer RoleAssumer, c *cache.Cache) RoleAssumer {
+	return &cachingRoleAssumer{
+		RoleAssumer: assumer,
+		cache:       c,
+	}
+}
+
+// AssumeRole caches credentials per role, session and the credentials used to assume it. Credentials of roles that
+// require MFA are reused until they expire without asking for another code.
+func (r *cachingRoleAssumer) AssumeRole(config aws.Config, assumeRole string, options AssumeRoleOptions) (*CredentialsProvider, error) {
+	return newRoleCredentialsProvider(func() (*sts.Credentials, error) {
+		return r.assumeRole(config, assumeRole, options)
+	}, options)
+}
+
+// assumeRole retrieves the source credentials every time, as they may have been refreshed themselves
+func (r *cachingRoleAssumer) assumeRole(config aws.Config, assumeRole string, options AssumeRoleOptions) (*sts.Credentials, error) {
+	source, err := config.Credentials.Retrieve(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials sts.Credentials
+	key := append([]string{"sts", source.AccessKeyID, assumeRole}, options.cacheKey()...)
+	err = r.cache.GetOrCreateWithin(key, &credentials, options.RefreshWindow, func() (time.Time, error) {
+		provider, err := r.RoleAssumer.AssumeRole(config, assumeRole, options)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		credentials = *provider.Credentials
+		return aws.TimeValue(credentials.Expiration), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials, nil
+}
+
+// AssumeRoleWithWebIdentity caches credentials per role and token, as tokens such as those of GitHub Actions are
+// issued for a single job
+func (r *cachingRoleAssumer) AssumeRoleWithWebIdentity(config aws.Config, assumeRole string, tokenFile string, options AssumeRoleOptions) (*CredentialsProvider, error) {
+	return NewRefreshingCredentialsProvider(func() (*sts.Credentials, error) {
+		return r.assumeRoleWithWebIdentity(config, assumeRole, tokenFile, options)
+	}, options.RefreshWindow)
+}
+
+func (r *cachingRoleAssumer) assumeRoleWithWebIdentity(config aws.Config, assumeRole string, tokenFile string, options AssumeRoleOptions) (*sts.Credentials, error) {
+	token, err := readWebIdentityToken(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials sts.Credentials
+	key := append([]string{"sts", "web-identity", fmt.Sprintf("%x", sha256.Sum256([]byte(token))), assumeRole}, options.cacheKey()...)
+	err = r.cache.GetOrCreateWithin(key, &credentials, options.RefreshWindow, func() (time.Time, error) {
+		provider, err := r.RoleAssumer.AssumeRoleWithWebIdentity(config, assumeRole, tokenFile, options)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		credentials = *provider.Credentials
+		return aws.TimeValue(credentials.Expiration), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials, nil
 }
 
 func NewRoleAssumer() RoleAssumer {
@@ -43,11 +264,85 @@ func NewRoleAssumer() RoleAssumer {
 	}
 }
 
+// CredentialsProvider provides the credentials of an assumed role. When it was created with a refresh function, the
+// role is assumed again shortly before the credentials expire, so that long pushes and long-running commands keep
+// working. It is safe for concurrent use.
 type CredentialsProvider struct {
 	*sts.Credentials
+
+	refresh func() (*sts.Credentials, error)
+	window  time.Duration
+	log     *log.Entry
+	now     func() time.Time
+	mutex   sync.Mutex
+
+	// expiredErr is returned once credentials that cannot be refreshed have expired, when set
+	expiredErr error
+}
+
+// NewRefreshingCredentialsProvider returns a provider of the credentials returned by 'refresh', which is called
+// immediately and again whenever the credentials expire within 'window'. A zero window means defaultRefreshWindow.
+func NewRefreshingCredentialsProvider(refresh func() (*sts.Credentials, error), window time.Duration) (*CredentialsProvider, error) {
+	credentials, err := refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	if window <= 0 {
+		window = defaultRefreshWindow
+	}
+
+	return &CredentialsProvider{
+		Credentials: credentials,
+		refresh:     refresh,
+		window:      window,
+		log:         log.WithField("component", "sts"),
+		now:         time.Now,
+	}, nil
+}
+
+// newRoleCredentialsProvider returns a provider of the credentials of the role assumed by 'assume'. The credentials of
+// roles that require MFA are not refreshed, as a code of an MFA device can only be used once and asking for another
+// one would block long-running commands. ErrMFASessionExpired is returned once they expire instead.
+func newRoleCredentialsProvider(assume func() (*sts.Credentials, error), options AssumeRoleOptions) (*CredentialsProvider, error) {
+	if options.MFASerial == "" {
+		return NewRefreshingCredentialsProvider(assume, options.RefreshWindow)
+	}
+
+	credentials, err := assume()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialsProvider{
+		Credentials: credentials,
+		log:         log.WithField("component", "sts"),
+		now:         time.Now,
+		expiredErr:  ErrMFASessionExpired,
+	}, nil
 }
 
-func (s CredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+func (s *CredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.expiredErr != nil && !s.now().Before(aws.TimeValue(s.Expiration)) {
+		return aws.Credentials{}, s.expiredErr
+	}
+
+	if s.refresh != nil && (s.Credentials == nil || !s.now().Add(s.window).Before(aws.TimeValue(s.Expiration))) {
+		credentials, err := s.refresh()
+		switch {
+		case err == nil:
+			s.Credentials = credentials
+		case s.Credentials != nil && s.now().Before(aws.TimeValue(s.Expiration)):
+			// The current credentials can still be used, and refreshing is tried again with the next request
+			s.log.WithError(err).Warn("Error refreshing credentials")
+		default:
+			return aws.Credentials{}, err
+		}
+	}
+
 	if s.Credentials == nil {
 		return aws.Credentials{}, ErrNoSTSCredentialsFound
 	}
@@ -56,6 +351,7 @@ func (s CredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, err
 		AccessKeyID:     aws.StringValue(s.AccessKeyId),
 		SecretAccessKey: aws.StringValue(s.SecretAccessKey),
 		SessionToken:    aws.StringValue(s.SessionToken),
+		CanExpire:       s.refresh != nil || s.expiredErr != nil,
 		Expires:         aws.TimeValue(s.Expiration),
 	}, nil
 }
@@ -2,11 +2,26 @@ package sts
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/hylandsoftware/trebuchet/internal/cache"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -42,3 +57,407 @@ func TestStsClient_Retrieve_ReturnsValidCredentials(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, expected, result)
 }
+
+// refreshCounter returns credentials that expire an hour after 'start' plus an hour per call
+type refreshCounter struct {
+	start time.Time
+	calls int32
+	err   error
+}
+
+func (c *refreshCounter) refresh() (*sts.Credentials, error) {
+	calls := atomic.AddInt32(&c.calls, 1)
+	if c.err != nil && calls > 1 {
+		return nil, c.err
+	}
+
+	return &sts.Credentials{
+		AccessKeyId:     aws.String(fmt.Sprintf("ASIA%d", calls)),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(c.start.Add(time.Duration(calls) * time.Hour)),
+	}, nil
+}
+
+func TestStsClient_Retrieve_RefreshesBeforeExpiration(t *testing.T) {
+	start := time.Now()
+	counter := &refreshCounter{start: start}
+	provider, err := NewRefreshingCredentialsProvider(counter.refresh, 10*time.Minute)
+	require.NoError(t, err)
+
+	provider.now = func() time.Time { return start.Add(49 * time.Minute) }
+	result, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "ASIA1", result.AccessKeyID)
+
+	provider.now = func() time.Time { return start.Add(51 * time.Minute) }
+	result, err = provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "ASIA2", result.AccessKeyID)
+	require.True(t, result.CanExpire)
+	require.Equal(t, start.Add(2*time.Hour), result.Expires)
+}
+
+func TestStsClient_Retrieve_UsesDefaultRefreshWindow(t *testing.T) {
+	start := time.Now()
+	counter := &refreshCounter{start: start}
+	provider, err := NewRefreshingCredentialsProvider(counter.refresh, 0)
+	require.NoError(t, err)
+
+	provider.now = func() time.Time { return start.Add(time.Hour - defaultRefreshWindow - time.Second) }
+	_, err = provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), counter.calls)
+
+	provider.now = func() time.Time { return start.Add(time.Hour - defaultRefreshWindow + time.Second) }
+	_, err = provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), counter.calls)
+}
+
+func TestStsClient_Retrieve_KeepsValidCredentialsWhenRefreshFails(t *testing.T) {
+	start := time.Now()
+	counter := &refreshCounter{start: start, err: errors.New("error")}
+	provider, err := NewRefreshingCredentialsProvider(counter.refresh, 10*time.Minute)
+	require.NoError(t, err)
+
+	provider.now = func() time.Time { return start.Add(55 * time.Minute) }
+	result, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "ASIA1", result.AccessKeyID)
+
+	provider.now = func() time.Time { return start.Add(61 * time.Minute) }
+	_, err = provider.Retrieve(context.Background())
+	require.EqualError(t, err, "error")
+}
+
+func TestStsClient_Retrieve_RefreshesOnceForConcurrentRequests(t *testing.T) {
+	start := time.Now()
+	counter := &refreshCounter{start: start}
+	provider, err := NewRefreshingCredentialsProvider(counter.refresh, 10*time.Minute)
+	require.NoError(t, err)
+	provider.now = func() time.Time { return start.Add(55 * time.Minute) }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := provider.Retrieve(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "ASIA2", result.AccessKeyID)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&counter.calls))
+}
+
+func TestStsClient_NewRefreshingCredentialsProvider_ReturnsInitialError(t *testing.T) {
+	_, err := NewRefreshingCredentialsProvider(func() (*sts.Credentials, error) {
+		return nil, errors.New("error")
+	}, 0)
+
+	require.EqualError(t, err, "error")
+}
+
+type countingRoleAssumer struct {
+	calls int
+}
+
+func (r *countingRoleAssumer) AssumeRole(config aws.Config, assumeRole string, options AssumeRoleOptions) (*CredentialsProvider, error) {
+	r.calls++
+	return &CredentialsProvider{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("ASIA" + assumeRole),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func (r *countingRoleAssumer) AssumeRoleWithWebIdentity(config aws.Config, assumeRole string, tokenFile string, options AssumeRoleOptions) (*CredentialsProvider, error) {
+	return r.AssumeRole(config, assumeRole, options)
+}
+
+func TestStsClient_CachingRoleAssumer_ReusesCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assumer := &countingRoleAssumer{}
+	config := aws.Config{Credentials: aws.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+
+	for i := 0; i < 2; i++ {
+		result, err := NewCachingRoleAssumer(assumer, cache.New(dir)).AssumeRole(config, "role", AssumeRoleOptions{})
+
+		require.NoError(t, err)
+		require.Equal(t, "ASIArole", aws.StringValue(result.AccessKeyId))
+	}
+
+	require.Equal(t, 1, assumer.calls)
+}
+
+func TestStsClient_CachingRoleAssumer_DoesNotReuseCredentialsWithinRefreshWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assumer := &countingRoleAssumer{}
+	config := aws.Config{Credentials: aws.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+
+	for i := 0; i < 2; i++ {
+		_, err := NewCachingRoleAssumer(assumer, cache.New(dir)).AssumeRole(config, "role", AssumeRoleOptions{RefreshWindow: 90 * time.Minute})
+
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, assumer.calls)
+}
+
+func TestStsClient_CachingRoleAssumer_SeparatesSourceCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assumer := &countingRoleAssumer{}
+
+	for _, accessKeyID := range []string{"AKID1", "AKID2"} {
+		config := aws.Config{Credentials: aws.NewStaticCredentialsProvider(accessKeyID, "SECRET", "")}
+		_, err := NewCachingRoleAssumer(assumer, cache.New(dir)).AssumeRole(config, "role", AssumeRoleOptions{})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, assumer.calls)
+}
+
+func TestStsClient_CachingRoleAssumer_DoesNotAskForMFATokenWhenCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assumer := &countingRoleAssumer{}
+	config := aws.Config{Credentials: aws.NewStaticCredentialsProvider("AKID", "SECRET", "")}
+	_, err = NewCachingRoleAssumer(assumer, cache.New(dir)).AssumeRole(config, "role", AssumeRoleOptions{})
+	require.NoError(t, err)
+
+	_, err = NewCachingRoleAssumer(NewRoleAssumer(), cache.New(dir)).AssumeRole(config, "role", AssumeRoleOptions{
+		MFASerial: "arn:aws:iam::112233445566:mfa/user",
+		MFAToken: func() (string, error) {
+			t.Fatal("MFA token must not be requested for cached credentials")
+			return "", nil
+		},
+	})
+
+	require.NoError(t, err)
+}
+
+func TestStsClient_CachingRoleAssumer_SeparatesWebIdentityTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assumer := &countingRoleAssumer{}
+
+	for _, token := range []string{"token1", "token1", "token2"} {
+		require.NoError(t, ioutil.WriteFile(tokenFile, []byte(token), 0600))
+		_, err := NewCachingRoleAssumer(assumer, cache.New(dir)).AssumeRoleWithWebIdentity(aws.Config{}, "role", tokenFile, AssumeRoleOptions{})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, assumer.calls)
+}
+
+func TestStsClient_AssumeRoleWithWebIdentity_SendsToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("oidc-token\n"), 0600))
+	var form url.Values
+	server := newFakeSTS(t, func(values url.Values) string {
+		form = values
+		return `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAWEB</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`
+	})
+	defer server.Close()
+
+	result, err := NewRoleAssumer().AssumeRoleWithWebIdentity(fakeSTSConfig(server), "arn:aws:iam::112233445566:role/ci", tokenFile, AssumeRoleOptions{SessionName: "ci", SourceIdentity: "github"})
+
+	require.NoError(t, err)
+	require.Equal(t, "ASIAWEB", aws.StringValue(result.AccessKeyId))
+	require.Equal(t, "AssumeRoleWithWebIdentity", form.Get("Action"))
+	require.Equal(t, "arn:aws:iam::112233445566:role/ci", form.Get("RoleArn"))
+	require.Equal(t, "oidc-token", form.Get("WebIdentityToken"))
+	require.Equal(t, "ci", form.Get("RoleSessionName"))
+	require.Equal(t, "github", form.Get("SourceIdentity"))
+}
+
+func TestStsClient_AssumeRole_SendsOptions(t *testing.T) {
+	var form url.Values
+	server := newFakeSTS(t, func(values url.Values) string {
+		form = values
+		return `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+	})
+	defer server.Close()
+
+	result, err := NewRoleAssumer().AssumeRole(fakeSTSConfig(server), "arn:aws:iam::112233445566:role/push", AssumeRoleOptions{
+		SessionName: "jenkins-app-42",
+		ExternalID:  "external-id",
+		Duration:    2 * time.Hour,
+		MFASerial:   "arn:aws:iam::112233445566:mfa/user",
+		MFAToken:    func() (string, error) { return "123456", nil },
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "ASIAROLE", aws.StringValue(result.AccessKeyId))
+	require.Equal(t, "jenkins-app-42", form.Get("RoleSessionName"))
+	require.Equal(t, "external-id", form.Get("ExternalId"))
+	require.Equal(t, "7200", form.Get("DurationSeconds"))
+	require.Equal(t, "arn:aws:iam::112233445566:mfa/user", form.Get("SerialNumber"))
+	require.Equal(t, "123456", form.Get("TokenCode"))
+}
+
+func TestStsClient_AssumeRole_SendsSessionTagsAndSourceIdentity(t *testing.T) {
+	var form url.Values
+	server := newFakeSTS(t, func(values url.Values) string {
+		form = values
+		return `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+	})
+	defer server.Close()
+
+	_, err := NewRoleAssumer().AssumeRole(fakeSTSConfig(server), "arn:aws:iam::112233445566:role/push", AssumeRoleOptions{
+		Tags:              map[string]string{"team": "platform", "env": "ci"},
+		TransitiveTagKeys: []string{"team"},
+		SourceIdentity:    "jenkins",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "env", form.Get("Tags.member.1.Key"))
+	require.Equal(t, "ci", form.Get("Tags.member.1.Value"))
+	require.Equal(t, "team", form.Get("Tags.member.2.Key"))
+	require.Equal(t, "platform", form.Get("Tags.member.2.Value"))
+	require.Equal(t, "team", form.Get("TransitiveTagKeys.member.1"))
+	require.Equal(t, "jenkins", form.Get("SourceIdentity"))
+	require.Equal(t, "AssumeRole", form.Get("Action"))
+}
+
+func TestStsClient_AssumeRole_LogsRequestID(t *testing.T) {
+	server := newFakeSTS(t, func(values url.Values) string {
+		return `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+	})
+	defer server.Close()
+	logger, hook := logtest.NewNullLogger()
+	assumer := &stsRoleAssumer{log: logger.WithField("component", "sts")}
+
+	_, err := assumer.AssumeRole(fakeSTSConfig(server), "arn:aws:iam::112233445566:role/push", AssumeRoleOptions{})
+
+	require.NoError(t, err)
+	var requests []*log.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Completed AWS request" {
+			requests = append(requests, entry)
+		}
+	}
+	require.Len(t, requests, 1)
+	require.Equal(t, "AssumeRole", requests[0].Data["operation"])
+	require.Equal(t, "request-id", requests[0].Data["requestId"])
+}
+
+func TestStsClient_AssumeRole_RequiresMFAToken(t *testing.T) {
+	_, err := NewRoleAssumer().AssumeRole(aws.Config{}, "role", AssumeRoleOptions{MFASerial: "mfa"})
+
+	require.Equal(t, ErrNoMFAToken, err)
+}
+
+func TestStsClient_AssumeRole_DoesNotRefreshMFACredentials(t *testing.T) {
+	calls := 0
+	server := newFakeSTS(t, func(values url.Values) string {
+		calls++
+		return `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAMFA</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+	})
+	defer server.Close()
+	tokens := 0
+	options := AssumeRoleOptions{MFASerial: "arn:aws:iam::112233445566:mfa/user", MFAToken: func() (string, error) {
+		tokens++
+		return "123456", nil
+	}}
+
+	result, err := NewRoleAssumer().AssumeRole(fakeSTSConfig(server), "arn:aws:iam::112233445566:role/push", options)
+	require.NoError(t, err)
+
+	result.now = func() time.Time { return time.Date(2029, 12, 31, 23, 59, 0, 0, time.UTC) }
+	credentials, err := result.Retrieve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "ASIAMFA", credentials.AccessKeyID)
+	require.True(t, credentials.CanExpire)
+
+	result.now = func() time.Time { return time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC) }
+	_, err = result.Retrieve(context.Background())
+	require.Equal(t, ErrMFASessionExpired, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, 1, tokens)
+}
+
+func TestStsClient_AssumeRoleWithWebIdentity_ReturnsErrorOnMissingTokenFile(t *testing.T) {
+	_, err := NewRoleAssumer().AssumeRoleWithWebIdentity(aws.Config{}, "role", "/does/not/exist", AssumeRoleOptions{})
+
+	require.True(t, os.IsNotExist(err))
+}
+
+// newFakeSTS starts an STS endpoint that responds to every request with the XML returned by 'respond'
+func newFakeSTS(t *testing.T, respond func(url.Values) string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		w.Header().Set("Content-Type", "text/xml")
+		w.Header().Set("X-Amzn-RequestId", "request-id")
+		_, _ = w.Write([]byte(respond(r.PostForm)))
+	}))
+}
+
+func fakeSTSConfig(server *httptest.Server) aws.Config {
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+	config.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	return config
+}